@@ -0,0 +1,180 @@
+// This file contains the non-interactive batch mode, for running hypo
+// programs from scripts.
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bdwalton/hypo/machine"
+)
+
+// Exit codes for batch runs. Flag parsing errors use 2, so the codes
+// for CPU faults start above that.
+const (
+	exitHalt     = 0 // The program halted normally
+	exitError    = 1 // The program couldn't be loaded
+	exitBadInst  = 3 // CPUbadinst
+	exitBadAddr  = 4 // CPUbadaddr
+	exitDivZero  = 5 // CPUdivzero
+	exitBadInput = 6 // CPUio
+	exitBudget   = 7 // The step budget ran out before the program stopped
+	exitStack    = 8 // CPUstack
+)
+
+// exitCodes maps the final CPU state of a batch run to the process
+// exit code.
+var exitCodes = map[machine.CPUState]int{
+	machine.CPUhalt:    exitHalt,
+	machine.CPUbadinst: exitBadInst,
+	machine.CPUbadaddr: exitBadAddr,
+	machine.CPUdivzero: exitDivZero,
+	machine.CPUio:      exitBadInput,
+	machine.CPUstack:   exitStack,
+}
+
+// batchConfig holds the optional settings for a batch run.
+type batchConfig struct {
+	maxSteps int            // Stop after this many instructions, if > 0
+	profile  io.Writer      // Where to write a pprof profile of the run, if not nil
+	coverage io.Writer      // Where to write an lcov coverage report of the run, if not nil
+	source   string         // The program path, used to name it in coverage reports
+	isa      machine.ISA    // How instructions are decoded
+	devices  []deviceSpec   // Reference devices to map into memory
+	channels map[int]chanIO // I/O channels other than 0
+	charset  machine.Charset
+	charOut  bool                // Whether PUT on channel 0 writes characters rather than numbers
+	inPolicy machine.InputPolicy // What GET and GCH do when input fails
+	inDef    int                 // The value read in place of failed input under machine.InputDefault
+}
+
+// runBatch loads the program from prog into a new machine and runs
+// it to completion, or until the step budget in cfg is used up. GET
+// values and GCH characters are taken from in, and PUT values written,
+// one per line, to out along with PCH characters. It returns the exit
+// code for the run.
+func runBatch(prog, in io.Reader, out io.Writer, cfg batchConfig) (code int, err error) {
+	p, err := machine.ParseProgram(prog)
+	if err != nil {
+		return exitError, err
+	}
+
+	br := bufio.NewReader(in)
+	get, put := machine.NumberGetter(br), func(i int) { fmt.Fprintln(out, i) }
+	if cfg.charOut {
+		put = machine.CharPutter(out, cfg.charset)
+	}
+	opts := []machine.Option{
+		machine.WithInput(get),
+		machine.WithOutput(put),
+		machine.WithCharInput(machine.CharGetter(br, cfg.charset)),
+		machine.WithCharOutput(machine.CharPutter(out, cfg.charset)),
+		machine.WithConsole(os.Stderr),
+		machine.WithISA(cfg.isa),
+		machine.WithInputPolicy(cfg.inPolicy, cfg.inDef),
+	}
+	opts = append(opts, channelOptions(cfg.channels, cfg.charset)...)
+	h := machine.New(opts...)
+	if err := mapDevices(h, cfg.devices, get, put); err != nil {
+		return exitError, err
+	}
+	h.Load(p)
+
+	if cfg.profile != nil {
+		h.StartProfile()
+		defer func() {
+			if perr := h.StopProfile().WritePprof(cfg.profile); perr != nil {
+				err = fmt.Errorf("writing profile: %v (run: %v)", perr, err)
+				if code == exitHalt {
+					code = exitError
+				}
+			}
+		}()
+	}
+
+	if cfg.coverage != nil {
+		h.StartCoverage()
+		defer func() {
+			if cerr := h.StopCoverage().WriteLcov(cfg.coverage, cfg.source); cerr != nil {
+				err = fmt.Errorf("writing coverage: %v (run: %v)", cerr, err)
+				if code == exitHalt {
+					code = exitError
+				}
+			}
+		}()
+	}
+
+	if r, _ := h.RunContext(context.Background(), cfg.maxSteps); r == machine.StopBudget {
+		return exitBudget, fmt.Errorf("program still running after %d steps at PC %02d", cfg.maxSteps, h.PC())
+	}
+
+	if h.State() == machine.CPUio {
+		return exitBadInput, fmt.Errorf("reading input at PC %02d: %v", h.PC(), h.InputError())
+	}
+	if h.State() != machine.CPUhalt {
+		return exitCodes[h.State()], fmt.Errorf("program terminated with %s at PC %02d", h.State(), h.PC())
+	}
+	return exitHalt, nil
+}
+
+// batch runs the program at path non-interactively, with the settings
+// in cfg, and exits with the code runBatch chooses. Input comes from
+// inPath, or stdin if it is empty or "-". If profPath isn't empty, a
+// pprof profile of the run is written there, and likewise an lcov
+// coverage report to covPath. The files in chans are attached to their
+// I/O channels.
+func batch(path, inPath, profPath, covPath string, chans []chanSpec, cfg batchConfig) {
+	pf, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening program file: %v\n", err)
+		os.Exit(exitError)
+	}
+	defer pf.Close()
+
+	in := os.Stdin
+	if inPath != "" && inPath != "-" {
+		if in, err = os.Open(inPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening input file: %v\n", err)
+			os.Exit(exitError)
+		}
+		defer in.Close()
+	}
+
+	channels, closeChannels, err := openChannels(chans)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening channel file: %v\n", err)
+		os.Exit(exitError)
+	}
+	defer closeChannels()
+
+	cfg.source, cfg.channels = path, channels
+	if profPath != "" {
+		f, err := os.Create(profPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating profile file: %v\n", err)
+			os.Exit(exitError)
+		}
+		defer f.Close()
+		cfg.profile = f
+	}
+	if covPath != "" {
+		f, err := os.Create(covPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating coverage file: %v\n", err)
+			os.Exit(exitError)
+		}
+		defer f.Close()
+		cfg.coverage = f
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	code, err := runBatch(pf, in, w, cfg)
+	w.Flush()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bdwalton/hypo/machine"
+)
+
+func TestRunBatch(t *testing.T) {
+	cases := []struct {
+		prog     string
+		in       string
+		wantOut  string
+		wantCode int
+	}{
+		{"0: 30010\n1: 31010", "42", "42\n", exitHalt},
+		{"0: 30010\n1: 30011\n2: 31011\n3: 31010", " 1\n\n-2 ", "-2\n1\n", exitHalt},
+		{"0: 30010\n1: 31010", "100000", "", exitBadInput},
+		{"0: 31002\n1: 32000\n2: 7", "", "7\n", exitBadInst},
+		{"0: 31002\n1: 05050\n2: 7", "", "7\n", exitBadAddr},
+		{"0: 12002\n1: 23003\n2: 7", "", "", exitDivZero},
+		{"0: 30010\n1: 30010", "1", "", exitBadInput},
+		{"0: 30010", "x", "", exitBadInput},
+		{"bogus", "", "", exitError},
+		{"0: 05000", "", "", exitBudget},
+	}
+
+	for i, c := range cases {
+		var out bytes.Buffer
+		code, err := runBatch(strings.NewReader(c.prog), strings.NewReader(c.in), &out, batchConfig{maxSteps: 1000})
+		if code != c.wantCode || out.String() != c.wantOut {
+			t.Errorf("%02d: runBatch(%q, %q) = (%d, %v) writing %q; want %d writing %q", i, c.prog, c.in, code, err, out.String(), c.wantCode, c.wantOut)
+		}
+		if (code == exitHalt) != (err == nil) {
+			t.Errorf("%02d: runBatch(%q, %q) returned exit code %d with error %v", i, c.prog, c.in, code, err)
+		}
+	}
+}
+
+func TestRunBatchInputPolicy(t *testing.T) {
+	prog := "0: 30010\n1: 31010\n2: 0"
+	cases := []struct {
+		policy   machine.InputPolicy
+		in       string
+		wantOut  string
+		wantCode int
+	}{
+		{machine.InputFail, "x 5", "", exitBadInput},
+		{machine.InputRetry, "x 100000 5", "5\n", exitHalt},
+		{machine.InputRetry, "x", "", exitBadInput},
+		{machine.InputDefault, "x", "-1\n", exitHalt},
+		{machine.InputDefault, "", "-1\n", exitHalt},
+	}
+
+	for i, c := range cases {
+		var out bytes.Buffer
+		code, err := runBatch(strings.NewReader(prog), strings.NewReader(c.in), &out, batchConfig{inPolicy: c.policy, inDef: -1})
+		if code != c.wantCode || out.String() != c.wantOut {
+			t.Errorf("%02d: runBatch() with %s and %q = (%d, %v) writing %q; want %d writing %q", i, c.policy, c.in, code, err, out.String(), c.wantCode, c.wantOut)
+		}
+	}
+}
+
+func TestRunBatchExample(t *testing.T) {
+	var out bytes.Buffer
+	prog := "examples/max.hypo"
+	pf, err := os.Open(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pf.Close()
+
+	code, err := runBatch(pf, strings.NewReader("3 17"), &out, batchConfig{})
+	if code != exitHalt || err != nil || out.String() != "17\n" {
+		t.Errorf("runBatch(%s, \"3 17\") = (%d, %v) writing %q; want (0, nil) writing \"17\\n\"", prog, code, err, out.String())
+	}
+}
+
+func TestRunBatchChars(t *testing.T) {
+	cases := []struct {
+		prog    string
+		in      string
+		cfg     batchConfig
+		wantOut string
+	}{
+		{"examples/hello.hypo", "", batchConfig{}, "Hello, world!\n"},
+		// Characters can follow a number on the same line of input, and
+		// PUT can write characters.
+		{"0: 30010\n1: 34011\n2: 31010\n3: 35011\n4: 0", "5 x\n", batchConfig{}, "5\nx"},
+		{"0: 30010\n1: 31010\n2: 0", "72", batchConfig{charOut: true}, "H"},
+		{"0: 34010\n1: 31010\n2: 35010\n3: 0", "H", batchConfig{charset: machine.CharsetPETSCII}, "200\nH"},
+	}
+
+	for i, c := range cases {
+		prog := strings.NewReader(c.prog)
+		if strings.HasPrefix(c.prog, "examples/") {
+			b, err := os.ReadFile(c.prog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prog = strings.NewReader(string(b))
+		}
+		var out bytes.Buffer
+		code, err := runBatch(prog, strings.NewReader(c.in), &out, c.cfg)
+		if code != exitHalt || err != nil || out.String() != c.wantOut {
+			t.Errorf("%02d: runBatch(%q, %q) = (%d, %v) writing %q; want (0, nil) writing %q", i, c.prog, c.in, code, err, out.String(), c.wantOut)
+		}
+	}
+
+	// A GCH loop stops at the end of input rather than spinning.
+	prog := "0: 34010\n1: 05000"
+	if code, err := runBatch(strings.NewReader(prog), strings.NewReader(""), &bytes.Buffer{}, batchConfig{maxSteps: 1000}); code != exitBadInput {
+		t.Errorf("runBatch(%q, \"\") = (%d, %v); want %d", prog, code, err, exitBadInput)
+	}
+}
+
+func TestRunBatchProfile(t *testing.T) {
+	var out, prof bytes.Buffer
+	code, err := runBatch(strings.NewReader("0: 31001\n1: 0"), strings.NewReader(""), &out, batchConfig{profile: &prof})
+	if code != exitHalt || err != nil {
+		t.Fatalf("runBatch() = (%d, %v); want (0, nil)", code, err)
+	}
+
+	z, err := gzip.NewReader(&prof)
+	if err != nil {
+		t.Fatalf("Profile isn't gzipped: %v", err)
+	}
+	if b, err := io.ReadAll(z); err != nil || len(b) == 0 {
+		t.Errorf("Reading profile = (%d bytes, %v); want data", len(b), err)
+	}
+}
+
+func TestRunBatchCoverage(t *testing.T) {
+	var out, cov bytes.Buffer
+	cfg := batchConfig{coverage: &cov, source: "prog.hypo"}
+	code, err := runBatch(strings.NewReader("0: 31001\n1: 0"), strings.NewReader(""), &out, cfg)
+	if code != exitHalt || err != nil {
+		t.Fatalf("runBatch() = (%d, %v); want (0, nil)", code, err)
+	}
+
+	want := "TN:\nSF:prog.hypo\nBRF:0\nBRH:0\nDA:1,1\nDA:2,1\nLF:2\nLH:2\nend_of_record\n"
+	if cov.String() != want {
+		t.Errorf("Coverage = %q; want %q", cov.String(), want)
+	}
+}
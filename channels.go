@@ -0,0 +1,122 @@
+// This file contains support for attaching files to I/O channels with
+// the -channels flag.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bdwalton/hypo/machine"
+)
+
+// chanSpec attaches the file at path to channel n, for input if in is
+// true and output otherwise.
+type chanSpec struct {
+	n    int
+	in   bool
+	path string
+}
+
+// parseChannels parses a comma separated list of channel files, each
+// written as n:in=path or n:out=path. Channel 0 is always stdin and
+// stdout, so n must be from 1 to machine.NumChannels-1.
+func parseChannels(s string) ([]chanSpec, error) {
+	var specs []chanSpec
+	if s == "" {
+		return nil, nil
+	}
+	seen := map[string]bool{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		parts := strings.SplitN(f, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid channel %q: want n:in=path or n:out=path", f)
+		}
+		ch := strings.SplitN(parts[0], ":", 2)
+		if len(ch) != 2 || (ch[1] != "in" && ch[1] != "out") {
+			return nil, fmt.Errorf("invalid channel %q: want n:in=path or n:out=path", f)
+		}
+		n, err := strconv.Atoi(ch[0])
+		if err != nil || n < 1 || n >= machine.NumChannels {
+			return nil, fmt.Errorf("invalid channel %q: the number must be from 1 to %d", f, machine.NumChannels-1)
+		}
+		if seen[parts[0]] {
+			return nil, fmt.Errorf("channel %s is given more than once", parts[0])
+		}
+		seen[parts[0]] = true
+		specs = append(specs, chanSpec{n, ch[1] == "in", parts[1]})
+	}
+	return specs, nil
+}
+
+// chanIO is where an I/O channel reads and writes. Either may be nil.
+type chanIO struct {
+	in  io.RuneReader
+	out io.Writer
+}
+
+// openChannels opens the files in specs, creating those for output.
+// The returned function closes them.
+func openChannels(specs []chanSpec) (map[int]chanIO, func(), error) {
+	chans := map[int]chanIO{}
+	var files []*os.File
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	for _, s := range specs {
+		c := chans[s.n]
+		if s.in {
+			f, err := os.Open(s.path)
+			if err != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("opening input for channel %d: %v", s.n, err)
+			}
+			files = append(files, f)
+			c.in = bufio.NewReader(f)
+		} else {
+			f, err := os.Create(s.path)
+			if err != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("creating output for channel %d: %v", s.n, err)
+			}
+			files = append(files, f)
+			c.out = f
+		}
+		chans[s.n] = c
+	}
+	return chans, closeAll, nil
+}
+
+// channelOptions returns the options attaching chans to a machine.
+// GET values are read separated by whitespace and PUT values written
+// one per line, and characters are read and written in cs.
+func channelOptions(chans map[int]chanIO, cs machine.Charset) []machine.Option {
+	var ns []int
+	for n := range chans {
+		ns = append(ns, n)
+	}
+	sort.Ints(ns)
+
+	var opts []machine.Option
+	for _, n := range ns {
+		c := chans[n]
+		var g, cg machine.Getter
+		var p, cp machine.Putter
+		if c.in != nil {
+			g, cg = machine.NumberGetter(c.in), machine.CharGetter(c.in, cs)
+		}
+		if c.out != nil {
+			p, cp = func(v int) { fmt.Fprintln(c.out, v) }, machine.CharPutter(c.out, cs)
+		}
+		opts = append(opts, machine.WithChannel(n, g, p), machine.WithCharChannel(n, cg, cp))
+	}
+	return opts
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseChannels(t *testing.T) {
+	cases := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{"", "[]", false},
+		{"2:out=diag.txt", "[{2 false diag.txt}]", false},
+		{"1:in=a.txt, 1:out=b.txt,9:in=c=d", "[{1 true a.txt} {1 false b.txt} {9 true c=d}]", false},
+		{"2=diag.txt", "", true},
+		{"2:err=diag.txt", "", true},
+		{"2:out=", "", true},
+		{"0:out=diag.txt", "", true},
+		{"10:out=diag.txt", "", true},
+		{"x:in=a.txt", "", true},
+		{"2:out=a.txt,2:out=b.txt", "", true},
+	}
+
+	for i, c := range cases {
+		specs, err := parseChannels(c.spec)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%02d: parseChannels(%q) = %v; want error %t", i, c.spec, err, c.wantErr)
+			continue
+		}
+		if got := fmt.Sprint(specs); err == nil && got != c.want {
+			t.Errorf("%02d: parseChannels(%q) = %s; want %s", i, c.spec, got, c.want)
+		}
+	}
+}
+
+func TestRunBatchChannels(t *testing.T) {
+	// Copy a value from channel 0 to channel 2, then one from channel
+	// 3 to channel 0.
+	prog := "0: 30020\n1: 33010\n2: 31020\n3: 33011\n4: 30020\n5: 33012\n6: 31020\n7: 0\n10: 2\n11: 3\n12: 0"
+	var out, diag bytes.Buffer
+	cfg := batchConfig{channels: map[int]chanIO{2: {out: &diag}, 3: {in: strings.NewReader("8")}}}
+	code, err := runBatch(strings.NewReader(prog), strings.NewReader("5"), &out, cfg)
+	if code != exitHalt || err != nil || out.String() != "8\n" || diag.String() != "5\n" {
+		t.Errorf("runBatch() with channels = (%d, %v) writing %q and %q; want (0, nil) writing \"8\\n\" and \"5\\n\"", code, err, out.String(), diag.String())
+	}
+
+	// Channel 3 has run out of input.
+	out.Reset()
+	cfg.channels[3] = chanIO{in: strings.NewReader("")}
+	if code, err := runBatch(strings.NewReader(prog), strings.NewReader("5"), &out, cfg); code != exitBadInput || err == nil {
+		t.Errorf("runBatch() with an empty channel = (%d, %v); want (%d, an error)", code, err, exitBadInput)
+	}
+
+	// Without channels, GET on channel 3 is an invalid memory reference.
+	if code, err := runBatch(strings.NewReader(prog), strings.NewReader("5"), &out, batchConfig{}); code != exitBadAddr || err == nil {
+		t.Errorf("runBatch() without channels = (%d, %v); want (%d, an error)", code, err, exitBadAddr)
+	}
+}
+
+func TestOpenChannels(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt")
+	if err := os.WriteFile(in, []byte("4 5"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	chans, closeChannels, err := openChannels([]chanSpec{{1, true, in}, {1, false, out}})
+	if err != nil {
+		t.Fatalf("openChannels() = %v; want nil", err)
+	}
+	prog := "0: 33010\n1: 30011\n2: 31011\n3: 0\n10: 1"
+	code, err := runBatch(strings.NewReader(prog), strings.NewReader(""), &bytes.Buffer{}, batchConfig{channels: chans})
+	closeChannels()
+	if b, _ := os.ReadFile(out); code != exitHalt || err != nil || string(b) != "4\n" {
+		t.Errorf("runBatch() with channel 1 files = (%d, %v) writing %q; want (0, nil) writing \"4\\n\"", code, err, b)
+	}
+
+	if _, _, err := openChannels([]chanSpec{{1, true, filepath.Join(dir, "missing.txt")}}); err == nil {
+		t.Errorf("openChannels() with a missing input = nil; want an error")
+	}
+}
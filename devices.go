@@ -0,0 +1,66 @@
+// This file contains support for mapping the reference devices into
+// memory with the -devices flag.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bdwalton/hypo/machine"
+)
+
+// deviceSpec names a reference device and the address to map it at.
+type deviceSpec struct {
+	kind string
+	addr int
+}
+
+// parseDevices parses a comma separated list of devices, each written
+// as kind@addr. The kinds are console, random and cycles.
+func parseDevices(s string) ([]deviceSpec, error) {
+	var specs []deviceSpec
+	if s == "" {
+		return nil, nil
+	}
+	for _, f := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(f), "@", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid device %q: want kind@addr", f)
+		}
+		kind := parts[0]
+		a, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid device %q: want kind@addr", f)
+		}
+		switch kind {
+		case "console", "random", "cycles":
+		default:
+			return nil, fmt.Errorf("unknown device %q", kind)
+		}
+		specs = append(specs, deviceSpec{kind, a})
+	}
+	return specs, nil
+}
+
+// mapDevices maps the devices in specs into h. Console devices read
+// from in and write to out, and random ones are seeded from the clock.
+func mapDevices(h *machine.Machine, specs []deviceSpec, in machine.Getter, out machine.Putter) error {
+	for _, s := range specs {
+		var d machine.Device
+		switch s.kind {
+		case "console":
+			d = &machine.Console{In: in, Out: out}
+		case "random":
+			d = machine.NewRandom(time.Now().UnixNano())
+		case "cycles":
+			d = &machine.Cycles{}
+		}
+		if err := h.MapDevice(s.addr, 1, d); err != nil {
+			return fmt.Errorf("mapping %s at %d: %v", s.kind, s.addr, err)
+		}
+	}
+	return nil
+}
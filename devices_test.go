@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseDevices(t *testing.T) {
+	cases := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{"", "[]", false},
+		{"console@49", "[{console 49}]", false},
+		{"console@40, random@41,cycles@42", "[{console 40} {random 41} {cycles 42}]", false},
+		{"console", "", true},
+		{"console@x", "", true},
+		{"disk@40", "", true},
+	}
+
+	for i, c := range cases {
+		specs, err := parseDevices(c.spec)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%02d: parseDevices(%q) = %v; want error %t", i, c.spec, err, c.wantErr)
+			continue
+		}
+		if got := fmt.Sprint(specs); err == nil && got != c.want {
+			t.Errorf("%02d: parseDevices(%q) = %s; want %s", i, c.spec, got, c.want)
+		}
+	}
+}
+
+func TestRunBatchDevices(t *testing.T) {
+	// Copy input to output through a console device at 49.
+	prog := "0: 10049\n1: 01004\n2: 11049\n3: 05000\n4: 0"
+	var out bytes.Buffer
+	cfg := batchConfig{devices: []deviceSpec{{"console", 49}}}
+	code, err := runBatch(strings.NewReader(prog), strings.NewReader("3 -4 0"), &out, cfg)
+	if code != exitHalt || err != nil || out.String() != "3\n-4\n" {
+		t.Errorf("runBatch() with a console = (%d, %v) writing %q; want (0, nil) writing \"3\\n-4\\n\"", code, err, out.String())
+	}
+
+	cfg.devices = append(cfg.devices, deviceSpec{"cycles", 49})
+	if code, err := runBatch(strings.NewReader(prog), strings.NewReader(""), &out, cfg); code != exitError || err == nil {
+		t.Errorf("runBatch() with overlapping devices = (%d, %v); want (%d, an error)", code, err, exitError)
+	}
+}
@@ -10,10 +10,35 @@ import (
 	"log"
 	"os"
 	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bdwalton/hypo/machine"
 )
 
 var (
 	progFile = flag.String("program", "", "Path to the hypo program to run, for use as the default program to be loaded.")
+	asmFile  = flag.String("assemble", "", "Path to a mnemonic source file to assemble. The program is written to stdout and hypo exits.")
+	compFile = flag.String("compile", "", "Path to a hypol source file to compile. The program is written to stdout and hypo exits.")
+	batchRun = flag.Bool("batch", false, "Run -program to completion without the BIOS. GET values are read from -input and PUT values written to stdout. The exit status reflects the final CPU state.")
+	inFile   = flag.String("input", "-", "Path to read GET values from in batch mode, or - for stdin.")
+	profFile = flag.String("profile", "", "Write a pprof profile of a batch mode run to this path.")
+	covFile  = flag.String("coverage", "", "Write an lcov coverage report of a batch mode run to this path.")
+	maxSteps = flag.Int("max-steps", 0, "Stop batch mode runs after this many instructions. 0 means no limit. With -explore, the limit for each path, defaulting to 1000.")
+	snapFile = flag.String("snapshot", "", "Path to a machine snapshot to restore before starting the BIOS.")
+	disFile  = flag.String("disassemble", "", "Path to a hypo program to disassemble. The listing is written to stdout and hypo exits.")
+	cfgFile  = flag.String("cfg", "", "Path to a hypo program to draw the control flow graph of. The graph is written to stdout in -cfg-format and hypo exits.")
+	cfgFmt   = flag.String("cfg-format", "dot", "The format for -cfg: dot (Graphviz) or mermaid.")
+	explFile = flag.String("explore", "", "Path to a hypo program to execute symbolically. The inputs reaching each HLT or fault are written to stdout and hypo exits.")
+	goFile   = flag.String("transpile", "", "Path to a hypo program to translate to a standalone Go program. The Go source is written to stdout and hypo exits.")
+	isaName  = flag.String("isa", "standard", "The instruction set: standard, or extended to use the middle digit of each instruction as an addressing mode.")
+	devList  = flag.String("devices", "", "Comma separated reference devices to map into memory, each as kind@addr. The kinds are console (reads and writes like GET and PUT), random and cycles.")
+	chanList = flag.String("channels", "", "Comma separated files to attach to I/O channels 1 to 9, each as n:in=path to read GET values from or n:out=path to write PUT values to. Channel 0 is always stdin and stdout.")
+	charName = flag.String("charset", "ascii", "The character set GCH and PCH use: ascii, or petscii for the Commodore 64's.")
+	outMode  = flag.String("output-mode", "numbers", "How PUT writes values to stdout: numbers, or chars to write the character with each value's code in -charset.")
+	inPolicy = flag.String("input-policy", "", "What GET and GCH do when input runs out or isn't a number: fail, retry to read again, or default to use -input-default. Defaults to retry in the BIOS and fail in batch mode.")
+	inDef    = flag.Int("input-default", 0, "The value read in place of failed input with -input-policy default.")
+	lintFile = flag.String("lint", "", "Path to a hypo program to check for likely mistakes. Issues are written to stdout and hypo exits with status 1 if there are any.")
 )
 
 type menuAction struct {
@@ -21,46 +46,354 @@ type menuAction struct {
 	action func() // The function to run for the action.
 }
 
-func loadProg(h *Machine) {
+// loadProg loads a program file into the machine. It returns the path
+// of the file, or "" if nothing was loaded.
+func loadProg(h *machine.Machine) string {
 	fmt.Printf("Program file path (default: %q): ", *progFile)
 	r := bufio.NewReader(os.Stdin)
 	input, err := r.ReadString('\n')
 	if err != nil {
 		log.Printf("Error reading program path: %v", err)
-		return
+		return ""
 	}
 
 	input = input[:len(input)-1]
-
-	var pf *os.File
-	switch input {
-	case "":
-		pf, err = os.Open(*progFile)
-	default:
-		pf, err = os.Open(input)
+	if input == "" {
+		input = *progFile
 	}
 
+	pf, err := os.Open(input)
 	if err != nil {
 		fmt.Printf("Error opening program file: %v\n", err)
+		return ""
+	}
+
+	defer pf.Close()
+
+	if err := h.LoadProgram(pf); err != nil {
+		fmt.Printf("Error loading program: %v\n", err)
+		return ""
+	}
+	return input
+}
+
+// promptPath asks the user for a file path. It returns false if no
+// path could be read.
+func promptPath(prompt string) (string, bool) {
+	fmt.Printf("%s: ", prompt)
+	r := bufio.NewReader(os.Stdin)
+	input, err := r.ReadString('\n')
+	if err != nil {
+		log.Printf("Error reading path: %v", err)
+		return "", false
+	}
+	return input[:len(input)-1], true
+}
+
+// asmProg assembles a mnemonic source file and loads the result into
+// the machine. It returns the path of the source file, or "" if
+// nothing was loaded.
+func asmProg(h *machine.Machine) string {
+	path, ok := promptPath("Source file path")
+	if !ok {
+		return ""
+	}
+
+	sf, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("Error opening source file: %v\n", err)
+		return ""
+	}
+	defer sf.Close()
+
+	a, err := machine.Assemble(sf)
+	if err != nil {
+		fmt.Printf("Error assembling program: %v\n", err)
+		return ""
+	}
+
+	h.Load(a)
+	fmt.Println("Program assembled and loaded successfully.")
+	return path
+}
+
+// saveSnap writes a snapshot of the machine to a file.
+func saveSnap(h *machine.Machine) {
+	path, ok := promptPath("Snapshot file path")
+	if !ok {
+		return
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Printf("Error creating snapshot file: %v\n", err)
+		return
+	}
+	defer f.Close()
+
+	if err := h.SaveSnapshot(f); err != nil {
+		fmt.Printf("Error saving snapshot: %v\n", err)
+		return
+	}
+	fmt.Println("Snapshot saved.")
+}
+
+// loadSnap restores the machine from a snapshot file.
+func loadSnap(h *machine.Machine) {
+	path, ok := promptPath("Snapshot file path")
+	if !ok {
+		return
+	}
+
+	if err := restoreSnap(h, path); err != nil {
+		fmt.Printf("Error loading snapshot: %v\n", err)
+		return
+	}
+	fmt.Println("Snapshot loaded.")
+}
+
+// restoreSnap restores the machine from the snapshot file at path.
+func restoreSnap(h *machine.Machine, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return h.LoadSnapshot(f)
+}
+
+// intArgs converts the arguments to a command into integers. It
+// returns false, after explaining why, if there aren't exactly n of
+// them or any aren't numbers.
+func intArgs(args []string, n int) ([]int, bool) {
+	if len(args) != n {
+		fmt.Printf("Expected %d argument(s), got %d.\n", n, len(args))
+		return nil, false
+	}
+
+	v := make([]int, n)
+	for i, a := range args {
+		var err error
+		if v[i], err = strconv.Atoi(a); err != nil {
+			fmt.Printf("Invalid number: %q\n", a)
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// setBreak handles "b ADDR".
+func setBreak(h *machine.Machine, args []string) {
+	v, ok := intArgs(args, 1)
+	if !ok {
+		return
+	}
+	id, err := h.SetBreakpoint(v[0])
+	if err != nil {
+		fmt.Printf("Error setting breakpoint: %v\n", err)
+		return
+	}
+	fmt.Printf("Breakpoint %d set at %02d.\n", id, v[0])
+}
+
+// setWatch handles "w ADDR [KIND]", where KIND defaults to c.
+func setWatch(h *machine.Machine, args []string) {
+	kind := "c"
+	if len(args) == 2 {
+		kind, args = args[1], args[:1]
+	}
+	v, ok := intArgs(args, 1)
+	if !ok {
+		return
+	}
+	k, err := machine.ParseWatchKind(kind)
+	if err != nil {
+		fmt.Printf("Error setting watchpoint: %v\n", err)
+		return
+	}
+	id, err := h.SetWatchpoint(v[0], k)
+	if err != nil {
+		fmt.Printf("Error setting watchpoint: %v\n", err)
+		return
+	}
+	fmt.Printf("Watchpoint %d set on %02d [%s].\n", id, v[0], k)
+}
+
+// editBreak applies f to the breakpoint or watchpoint id in args.
+func editBreak(args []string, f func(int) error) {
+	v, ok := intArgs(args, 1)
+	if !ok {
+		return
+	}
+	if err := f(v[0]); err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+}
+
+// stepBack handles "sb", explaining when there's nothing to undo.
+func stepBack(h *machine.Machine) {
+	if !h.StepBack() {
+		fmt.Println("No recorded history to step back through.")
+	}
+}
+
+// showProf displays a report of prof.
+func showProf(prof *machine.Profile) {
+	if prof == nil {
+		fmt.Println("No profile has been collected.")
+		return
+	}
+	prof.Report(os.Stdout)
+}
+
+// writeProf writes prof to a file in pprof format.
+func writeProf(prof *machine.Profile) {
+	if prof == nil {
+		fmt.Println("No profile has been collected.")
+		return
+	}
+
+	path, ok := promptPath("Profile file path")
+	if !ok {
+		return
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Printf("Error creating profile file: %v\n", err)
+		return
+	}
+	defer f.Close()
+
+	if err := prof.WritePprof(f); err != nil {
+		fmt.Printf("Error writing profile: %v\n", err)
+		return
+	}
+	fmt.Println("Profile written.")
+}
+
+// writeCFG writes g to stdout in format, which is dot or mermaid.
+func writeCFG(g *machine.CFG, format string) error {
+	switch format {
+	case "dot":
+		return g.WriteDot(os.Stdout)
+	case "mermaid":
+		return g.WriteMermaid(os.Stdout)
+	}
+	return fmt.Errorf("Unknown graph format %q; want dot or mermaid", format)
+}
+
+// showCFG displays the control flow graph of the machine's memory in
+// the format named by args, which defaults to dot.
+func showCFG(h *machine.Machine, args []string) {
+	format := "dot"
+	if len(args) > 0 {
+		format = args[0]
+	}
+	if err := writeCFG(h.CFG(), format); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// explore displays the paths from the machine's current state found
+// by symbolic execution.
+func explore(h *machine.Machine) {
+	results, complete := h.Explore(0, 0)
+	machine.WriteExploration(os.Stdout, results, complete)
+}
+
+// lint displays the likely mistakes found in the machine's memory.
+func lint(h *machine.Machine) {
+	issues := h.Lint()
+	for _, l := range issues {
+		fmt.Println(l)
+	}
+	fmt.Printf("%d issues found.\n", len(issues))
+}
+
+// showCover displays a report of cov.
+func showCover(cov *machine.Coverage) {
+	if cov == nil {
+		fmt.Println("No coverage has been collected.")
+		return
+	}
+	cov.Report(os.Stdout)
+}
+
+// writeCover writes cov to a file in lcov format, attributing it to
+// the program source file at source.
+func writeCover(cov *machine.Coverage, source string) {
+	if cov == nil {
+		fmt.Println("No coverage has been collected.")
+		return
+	}
+
+	path, ok := promptPath("Coverage file path")
+	if !ok {
 		return
 	}
 
-	h.LoadProgram(pf)
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Printf("Error creating coverage file: %v\n", err)
+		return
+	}
+	defer f.Close()
+
+	if err := cov.WriteLcov(f, source); err != nil {
+		fmt.Printf("Error writing coverage: %v\n", err)
+		return
+	}
+	fmt.Println("Coverage written.")
 }
 
-func bios(h *Machine) {
+func bios(h *machine.Machine) {
+	var args []string         // Arguments following the command, for actions that take them.
+	var prof *machine.Profile // The most recent profile
+	var cov *machine.Coverage // The most recent coverage
+	source := *progFile       // The source of the most recently loaded program
+	loaded := func(path string) {
+		if path != "" {
+			source = path
+		}
+	}
+
 	menu := map[string]menuAction{
-		"?": menuAction{"display this help text", nil},
-		"g": menuAction{"run program to halt state (go!)", h.Run},
-		"h": menuAction{"display this help text", nil},
-		"l": menuAction{"load program from file", func() { loadProg(h) }},
-		"m": menuAction{"display memory", h.DumpMem},
-		"q": menuAction{"quit hypo", func() { fmt.Println("Bye!"); os.Exit(0) }},
-		"r": menuAction{"dump register contents", h.DumpRegs},
-		"s": menuAction{"step program forward by one instruction", h.Step},
-		"t": menuAction{"toggle execution tracing", h.ToggleTrace},
-		"x": menuAction{"dump all machine state", h.DumpState},
-		"z": menuAction{"reboot/reset the CPU state", h.ResetCPU},
+		"?":  menuAction{"display this help text", nil},
+		"a":  menuAction{"assemble and load program from mnemonic source", func() { loaded(asmProg(h)) }},
+		"b":  menuAction{"set a breakpoint on a PC address: b ADDR", func() { setBreak(h, args) }},
+		"bd": menuAction{"disable a breakpoint or watchpoint: bd ID", func() { editBreak(args, h.DisableBreakpoint) }},
+		"be": menuAction{"enable a breakpoint or watchpoint: be ID", func() { editBreak(args, h.EnableBreakpoint) }},
+		"bl": menuAction{"list breakpoints and watchpoints", h.DumpBreakpoints},
+		"bx": menuAction{"delete a breakpoint or watchpoint: bx ID", func() { editBreak(args, h.DeleteBreakpoint) }},
+		"cg": menuAction{"show the control flow graph of memory: cg [dot|mermaid]", func() { showCFG(h, args) }},
+		"cr": menuAction{"show the code coverage", func() { showCover(cov) }},
+		"cs": menuAction{"start collecting code coverage", func() { cov = h.StartCoverage(); fmt.Println("Coverage started.") }},
+		"cw": menuAction{"write the code coverage to a file in lcov format", func() { writeCover(cov, source) }},
+		"cx": menuAction{"stop collecting code coverage", func() { h.StopCoverage(); fmt.Println("Coverage stopped.") }},
+		"d":  menuAction{"disassemble memory", func() { h.Disassemble(os.Stdout) }},
+		"g":  menuAction{"run program to halt state (go!)", h.Run},
+		"gb": menuAction{"run program backwards to a breakpoint", h.RunBack},
+		"h":  menuAction{"display this help text", nil},
+		"l":  menuAction{"load program from file", func() { loaded(loadProg(h)) }},
+		"li": menuAction{"check memory for likely mistakes (lint)", func() { lint(h) }},
+		"ls": menuAction{"load machine snapshot from file", func() { loadSnap(h) }},
+		"m":  menuAction{"display memory", h.DumpMem},
+		"pr": menuAction{"show the execution profile", func() { showProf(prof) }},
+		"ps": menuAction{"start collecting an execution profile", func() { prof = h.StartProfile(); fmt.Println("Profiling started.") }},
+		"pw": menuAction{"write the execution profile to a file in pprof format", func() { writeProf(prof) }},
+		"px": menuAction{"stop collecting the execution profile", func() { h.StopProfile(); fmt.Println("Profiling stopped.") }},
+		"q":  menuAction{"quit hypo", func() { fmt.Println("Bye!"); os.Exit(0) }},
+		"r":  menuAction{"dump register contents", h.DumpRegs},
+		"s":  menuAction{"step program forward by one instruction", h.Step},
+		"se": menuAction{"explore paths from the current state with symbolic inputs", func() { explore(h) }},
+		"sb": menuAction{"step program backward by one instruction", func() { stepBack(h) }},
+		"ss": menuAction{"save machine snapshot to file", func() { saveSnap(h) }},
+		"t":  menuAction{"toggle execution tracing", h.ToggleTrace},
+		"w":  menuAction{"set a watchpoint on a memory cell: w ADDR [r|w|c] (read, write or change; default c)", func() { setWatch(h, args) }},
+		"x":  menuAction{"dump all machine state", h.DumpState},
+		"z":  menuAction{"reboot/reset the CPU state", h.ResetCPU},
 	}
 
 	r := bufio.NewReader(os.Stdin)
@@ -80,6 +413,10 @@ func bios(h *Machine) {
 		}
 
 		command := input[:len(input)-1]
+		args = nil
+		if f := strings.Fields(command); len(f) > 0 {
+			command, args = f[0], f[1:]
+		}
 		if item, ok := menu[command]; ok {
 			switch item.action {
 			case nil:
@@ -103,6 +440,179 @@ func bios(h *Machine) {
 
 func main() {
 	flag.Parse()
-	hm := NewMachine()
+
+	isa, ok := machine.ParseISA(*isaName)
+	if !ok {
+		log.Fatalf("Unknown instruction set %q.", *isaName)
+	}
+	devices, err := parseDevices(*devList)
+	if err != nil {
+		log.Fatal(err)
+	}
+	chans, err := parseChannels(*chanList)
+	if err != nil {
+		log.Fatal(err)
+	}
+	charset, ok := machine.ParseCharset(*charName)
+	if !ok {
+		log.Fatalf("Unknown character set %q.", *charName)
+	}
+	if *outMode != "numbers" && *outMode != "chars" {
+		log.Fatalf("Unknown output mode %q.", *outMode)
+	}
+	policy := machine.InputRetry
+	if *batchRun {
+		policy = machine.InputFail
+	}
+	if *inPolicy != "" {
+		if policy, ok = machine.ParseInputPolicy(*inPolicy); !ok {
+			log.Fatalf("Unknown input policy %q.", *inPolicy)
+		}
+	}
+	if *inDef < -99999 || *inDef > 99999 {
+		log.Fatalf("Invalid input default %d: outside -99999 to 99999.", *inDef)
+	}
+
+	if flag.Arg(0) == "test" {
+		if flag.NArg() < 2 {
+			log.Fatal("Usage: hypo test SPEC...")
+		}
+		specTest(flag.Args()[1:])
+	}
+
+	if *asmFile != "" {
+		sf, err := os.Open(*asmFile)
+		if err != nil {
+			log.Fatalf("Error opening source file: %v", err)
+		}
+		a, err := machine.Assemble(sf)
+		if err != nil {
+			log.Fatalf("Error assembling program: %v", err)
+		}
+		a.WriteTo(os.Stdout)
+		return
+	}
+
+	if *compFile != "" {
+		sf, err := os.Open(*compFile)
+		if err != nil {
+			log.Fatalf("Error opening source file: %v", err)
+		}
+		p, err := machine.Compile(sf)
+		if err != nil {
+			log.Fatalf("Error compiling program: %v", err)
+		}
+		p.WriteTo(os.Stdout)
+		return
+	}
+
+	if *disFile != "" {
+		pf, err := os.Open(*disFile)
+		if err != nil {
+			log.Fatalf("Error opening program file: %v", err)
+		}
+		p, err := machine.ParseProgram(pf)
+		if err != nil {
+			log.Fatalf("Error reading program: %v", err)
+		}
+		p.DisassembleISA(os.Stdout, isa)
+		return
+	}
+
+	if *cfgFile != "" {
+		pf, err := os.Open(*cfgFile)
+		if err != nil {
+			log.Fatalf("Error opening program file: %v", err)
+		}
+		p, err := machine.ParseProgram(pf)
+		if err != nil {
+			log.Fatalf("Error reading program: %v", err)
+		}
+		if err := writeCFG(p.CFGISA(isa), *cfgFmt); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *explFile != "" {
+		pf, err := os.Open(*explFile)
+		if err != nil {
+			log.Fatalf("Error opening program file: %v", err)
+		}
+		p, err := machine.ParseProgram(pf)
+		if err != nil {
+			log.Fatalf("Error reading program: %v", err)
+		}
+		results, complete := p.ExploreISA(isa, *maxSteps, 0)
+		machine.WriteExploration(os.Stdout, results, complete)
+		return
+	}
+
+	if *goFile != "" {
+		pf, err := os.Open(*goFile)
+		if err != nil {
+			log.Fatalf("Error opening program file: %v", err)
+		}
+		p, err := machine.ParseProgram(pf)
+		if err != nil {
+			log.Fatalf("Error reading program: %v", err)
+		}
+		if err := p.TranspileISA(os.Stdout, *goFile, isa); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *lintFile != "" {
+		pf, err := os.Open(*lintFile)
+		if err != nil {
+			log.Fatalf("Error opening program file: %v", err)
+		}
+		p, err := machine.ParseProgram(pf)
+		if err != nil {
+			log.Fatalf("Error reading program: %v", err)
+		}
+		issues := p.LintISA(isa)
+		for _, l := range issues {
+			fmt.Printf("%s: %s\n", *lintFile, l)
+		}
+		if len(issues) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *batchRun {
+		if *progFile == "" {
+			log.Fatal("Batch mode requires -program.")
+		}
+		cfg := batchConfig{maxSteps: *maxSteps, isa: isa, devices: devices, charset: charset, charOut: *outMode == "chars", inPolicy: policy, inDef: *inDef}
+		batch(*progFile, *inFile, *profFile, *covFile, chans, cfg)
+	}
+
+	channels, closeChannels, err := openChannels(chans)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeChannels()
+	opts := []machine.Option{
+		machine.WithISA(isa),
+		machine.WithInputPolicy(policy, *inDef),
+		machine.WithCharInput(machine.CharGetter(machine.Stdin, charset)),
+		machine.WithCharOutput(machine.CharPutter(os.Stdout, charset)),
+		machine.WithHistory(),
+	}
+	if *outMode == "chars" {
+		opts = append(opts, machine.WithOutput(machine.CharPutter(os.Stdout, charset)))
+	}
+	hm := machine.New(append(opts, channelOptions(channels, charset)...)...)
+	if err := mapDevices(hm, devices, machine.Input, machine.Output); err != nil {
+		log.Fatal(err)
+	}
+	if *snapFile != "" {
+		if err := restoreSnap(hm, *snapFile); err != nil {
+			log.Fatalf("Error loading snapshot: %v", err)
+		}
+	}
 	bios(hm)
 }
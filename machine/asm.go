@@ -0,0 +1,217 @@
+// This file contains the hypo assembler. It turns mnemonic source,
+// using the names from the ops table, into the addr: value program
+// format understood by Machine.LoadProgram.
+
+package machine
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Errors returned, wrapped with the source line, by Assemble.
+var (
+	ErrAsmBadLine        = errors.New("Invalid line in source")
+	ErrAsmBadOp          = errors.New("Unknown mnemonic or directive")
+	ErrAsmBadOperand     = errors.New("Invalid operand")
+	ErrAsmBadAddr        = errors.New("Invalid memory address - can't assemble there")
+	ErrAsmReused         = errors.New("Memory address assembled more than once")
+	ErrAsmDupLabel       = errors.New("Label defined more than once")
+	ErrAsmUndefLabel     = errors.New("Undefined label")
+	ErrAsmMissingOperand = errors.New("Missing operand")
+)
+
+// Assembler directives. These are accepted anywhere a mnemonic is.
+const (
+	dirOrg = "ORG" // Set the address the next word is assembled to
+	dirDat = "DAT" // Store a literal data word
+)
+
+// opcodes maps mnemonics back to their numeric op codes. It is the
+// inverse of ops.
+var opcodes = func() map[string]int {
+	m := make(map[string]int, len(ops))
+	for c, o := range ops {
+		m[o] = c
+	}
+	return m
+}()
+
+var labelRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// stmt is a single word producing statement from the source, kept
+// between the two assembler passes.
+type stmt struct {
+	line    int    // Source line number, for error reporting
+	addr    int    // Address the word is assembled to
+	op      string // Mnemonic or DAT
+	operand string // Raw operand text, possibly empty
+	comment string // Trailing comment, without the leading //
+}
+
+// Assemble reads mnemonic source from r and returns the assembled
+// program, with labels and source line numbers attached. Each source line has the form:
+//
+//	[label:] [MNEMONIC [operand]] [// comment]
+//
+// Operands are either numbers or labels. The ORG directive moves
+// assembly to a new address and DAT stores a literal word, which may
+// also be a label's address. Instruction operands prefixed with # or @
+// use the extended ISA's immediate or indirect addressing. Errors
+// wrap one of the ErrAsm values.
+func Assemble(r io.Reader) (*Program, error) {
+	a := &Program{labels: map[string]int{}}
+	var stmts []stmt
+
+	// Pass one assigns addresses to labels and statements.
+	loc, n := 0, 0
+	var pending []string // Labels waiting for the next word
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		n++
+		line, comment := s.Text(), ""
+		if i := strings.Index(line, "//"); i >= 0 {
+			line, comment = line[:i], strings.TrimSpace(line[i+2:])
+		}
+
+		f := strings.Fields(line)
+		if len(f) > 0 && strings.HasSuffix(f[0], ":") {
+			l := strings.TrimSuffix(f[0], ":")
+			if !labelRE.MatchString(l) {
+				return nil, fmt.Errorf("line %d: %q: %w", n, l, ErrAsmBadLine)
+			}
+			if _, ok := a.labels[l]; ok {
+				return nil, fmt.Errorf("line %d: %q: %w", n, l, ErrAsmDupLabel)
+			}
+			a.labels[l] = -1
+			pending = append(pending, l)
+			f = f[1:]
+		}
+
+		switch len(f) {
+		case 0:
+			continue
+		case 1, 2:
+		default:
+			return nil, fmt.Errorf("line %d: %q: %w", n, s.Text(), ErrAsmBadLine)
+		}
+
+		op, operand := strings.ToUpper(f[0]), ""
+		if len(f) == 2 {
+			operand = f[1]
+		}
+
+		if op == dirOrg {
+			v, err := strconv.Atoi(operand)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %q: %w", n, operand, ErrAsmBadOperand)
+			}
+			if !inBounds(v) {
+				return nil, fmt.Errorf("line %d: %d: %w", n, v, ErrAsmBadAddr)
+			}
+			loc = v
+			continue
+		}
+
+		if _, ok := opcodes[op]; !ok && op != dirDat {
+			return nil, fmt.Errorf("line %d: %q: %w", n, f[0], ErrAsmBadOp)
+		}
+		if !inBounds(loc) {
+			return nil, fmt.Errorf("line %d: %d: %w", n, loc, ErrAsmBadAddr)
+		}
+		if a.used[loc] {
+			return nil, fmt.Errorf("line %d: %d: %w", n, loc, ErrAsmReused)
+		}
+		a.used[loc] = true
+
+		for _, l := range pending {
+			a.labels[l] = loc
+		}
+		if len(pending) > 0 {
+			comment = strings.TrimSpace(strings.Join(pending, ": ") + ": " + comment)
+		}
+		pending = nil
+
+		stmts = append(stmts, stmt{n, loc, op, operand, comment})
+		loc++
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	// A trailing label with no word after it refers to the next free
+	// address. That is only useful if the address exists.
+	for _, l := range pending {
+		if !inBounds(loc) {
+			return nil, fmt.Errorf("line %d: %q: %w", n, l, ErrAsmBadAddr)
+		}
+		a.labels[l] = loc
+	}
+
+	// Pass two resolves operands now that all labels are known.
+	for _, st := range stmts {
+		v, err := a.resolve(st)
+		if err != nil {
+			return nil, err
+		}
+		a.mem[st.addr] = v
+		a.comments[st.addr] = st.comment
+		a.lines[st.addr] = st.line
+	}
+
+	return a, nil
+}
+
+// resolve computes the memory word for a statement.
+func (a *Program) resolve(st stmt) (int, error) {
+	if st.operand == "" {
+		// Only HLT, RET and RTI are meaningful without a target
+		// address.
+		if st.op != "HLT" && st.op != "RET" && st.op != "RTI" {
+			return 0, fmt.Errorf("line %d: %s: %w", st.line, st.op, ErrAsmMissingOperand)
+		}
+		return opcodes[st.op] * 1000, nil
+	}
+
+	// A prefix selects an extended ISA addressing mode.
+	operand, mode := st.operand, modeDirect
+	if st.op != dirDat {
+		for m, p := range modePrefix {
+			if strings.HasPrefix(operand, p) {
+				operand, mode = operand[len(p):], m
+			}
+		}
+	}
+
+	v, err := strconv.Atoi(operand)
+	if err != nil {
+		l, ok := a.labels[operand]
+		if !ok {
+			return 0, fmt.Errorf("line %d: %q: %w", st.line, operand, ErrAsmUndefLabel)
+		}
+		v = l
+	}
+
+	if st.op == dirDat {
+		if v != boundsCap(v) {
+			return 0, fmt.Errorf("line %d: %d: %w", st.line, v, ErrAsmBadOperand)
+		}
+		return v, nil
+	}
+
+	switch {
+	case mode == modeImmediate && (v < 0 || v > 99):
+		return 0, fmt.Errorf("line %d: %d: %w", st.line, v, ErrAsmBadOperand)
+	case mode != modeImmediate && !inBounds(v):
+		return 0, fmt.Errorf("line %d: %d: %w", st.line, v, ErrAsmBadAddr)
+	case !modeAllowed(st.op, mode):
+		return 0, fmt.Errorf("line %d: %q: %w", st.line, st.operand, ErrAsmBadOperand)
+	}
+	return opcodes[st.op]*1000 + int(mode)*100 + v, nil
+}
@@ -0,0 +1,122 @@
+package machine
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	cases := []struct {
+		src     string
+		want    map[int]int // Expected non-zero memory contents
+		wantErr error
+	}{
+		{"PUT 2\nJMP 0\nDAT 99103", map[int]int{0: 31002, 1: 5000, 2: 99103}, nil},
+		{"put 2 // lower case\njmp 0", map[int]int{0: 31002, 1: 5000}, nil},
+		{"start: PUT val\nJMP start\nval: DAT 99103", map[int]int{0: 31002, 1: 5000, 2: 99103}, nil},
+		{"JMP end // Forward reference\nORG 49\nend: HLT", map[int]int{0: 5049}, nil},
+		{"a:\nb: LAC a // Stacked labels\nDAT b", map[int]int{0: 10000}, nil},
+		{"ORG 10\nptr: DAT ptr // Label value as data", map[int]int{10: 10}, nil},
+		{"DAT -5", map[int]int{0: -5}, nil},
+		{"HLT\nend:", map[int]int{}, nil},
+		{"CAL sub\nHLT\nsub: RET", map[int]int{0: 8002, 2: 9000}, nil},
+		{"CAL @p\np: DAT 0", map[int]int{0: 8201}, nil},
+		{"VEC h\nHLT\nh: LEP #3\nRTI", map[int]int{0: 14002, 2: 15103, 3: 4000}, nil},
+		{"LAC #7\nPAC @p\nJMP @p\np: DAT 0", map[int]int{0: 10107, 1: 11203, 2: 5203}, nil},
+		{"LAC #99", map[int]int{0: 10199}, nil},
+		{"FOO 1", nil, ErrAsmBadOp},
+		{"LAC", nil, ErrAsmMissingOperand},
+		{"LAC nowhere", nil, ErrAsmUndefLabel},
+		{"LAC 50", nil, ErrAsmBadAddr},
+		{"ORG 50", nil, ErrAsmBadAddr},
+		{"ORG 49\nHLT\nHLT", nil, ErrAsmBadAddr},
+		{"ORG x", nil, ErrAsmBadOperand},
+		{"DAT 100000", nil, ErrAsmBadOperand},
+		{"x: HLT\nx: HLT", nil, ErrAsmDupLabel},
+		{"HLT\nORG 0\nHLT", nil, ErrAsmReused},
+		{"LAC 1 2", nil, ErrAsmBadLine},
+		{"1x: HLT", nil, ErrAsmBadLine},
+		{"LAC #100", nil, ErrAsmBadOperand},
+		{"LAC @50", nil, ErrAsmBadAddr},
+		{"PAC #1", nil, ErrAsmBadOperand},
+		{"DAT #1", nil, ErrAsmUndefLabel},
+	}
+
+	for i, c := range cases {
+		a, err := Assemble(strings.NewReader(c.src))
+		if !errors.Is(err, c.wantErr) {
+			t.Errorf("%02d: Assemble(%q) = %v; want %v", i, c.src, err, c.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		var want [MemSize]int
+		for k, v := range c.want {
+			want[k] = v
+		}
+		if !reflect.DeepEqual(a.mem, want) {
+			t.Errorf("%02d: Assemble(%q).mem = %v; want %v", i, c.src, a.mem, want)
+		}
+	}
+}
+
+func TestAssemblyWriteTo(t *testing.T) {
+	src := "loop: PUT val // Print it\nJMP loop\nORG 10\nval: DAT -5"
+	want := "00: 31010 // loop: Print it\n01: 05000\n10: -0005 // val:\n"
+
+	a, err := Assemble(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("Assemble(%q) = %v; want nil", src, err)
+	}
+
+	var b bytes.Buffer
+	if _, err := a.WriteTo(&b); err != nil {
+		t.Fatalf("a.WriteTo() = %v; want nil", err)
+	}
+	if got := b.String(); got != want {
+		t.Errorf("a.WriteTo() wrote %q; want %q", got, want)
+	}
+
+	// The output must round trip through the program loader.
+	h := New()
+	if err := h.LoadProgram(&b); err != nil {
+		t.Fatalf("h.LoadProgram() = %v; want nil", err)
+	}
+	if h.mem != a.mem {
+		t.Errorf("h.mem = %v; want %v", h.mem, a.mem)
+	}
+}
+
+func TestAssembleExample(t *testing.T) {
+	src, err := os.Open("../examples/fibonacci.hasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	prog, err := os.Open("../examples/fibonacci.hypo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	a, err := Assemble(src)
+	if err != nil {
+		t.Fatalf("Assemble(fibonacci.hasm) = %v; want nil", err)
+	}
+
+	h := New()
+	if err := h.LoadProgram(prog); err != nil {
+		t.Fatalf("h.LoadProgram(fibonacci.hypo) = %v; want nil", err)
+	}
+
+	if a.mem != h.mem {
+		t.Errorf("Assemble(fibonacci.hasm).mem = %v; want %v", a.mem, h.mem)
+	}
+}
@@ -0,0 +1,79 @@
+// This file contains the device bus, which maps ranges of memory
+// addresses to devices implemented in Go.
+
+package machine
+
+import "errors"
+
+// Errors returned by MapDevice.
+var (
+	ErrDevRange   = errors.New("Device range outside memory")
+	ErrDevOverlap = errors.New("Device range overlaps another device")
+)
+
+// Device is a peripheral mapped into a range of memory addresses.
+// Instructions that read a mapped address call Read, and those that
+// write one call Write, in place of using memory. Each is passed the
+// offset of the address from the start of the range. Reads are input,
+// so an error from Read, or a value memory can't hold, is handled by
+// the machine's input policy as it is for GET.
+type Device interface {
+	Read(off int) (int, error)
+	Write(off, v int)
+}
+
+// Ticker is implemented by devices that need to know about the
+// passage of time. Tick is called after every instruction the
+// machine executes.
+type Ticker interface {
+	Tick()
+}
+
+// mapping is a device's place on the bus.
+type mapping struct {
+	dev  Device
+	base int // The first address mapped to dev
+}
+
+// MapDevice maps size addresses starting at base to d. The memory
+// underneath is left as it was, and is what dumps, disassembly and
+// snapshots show. It returns ErrDevRange if the range doesn't fit in
+// memory and ErrDevOverlap if it overlaps another device's.
+func (h *Machine) MapDevice(base, size int, d Device) error {
+	if size < 1 || !inBounds(base) || !inBounds(base+size-1) {
+		return ErrDevRange
+	}
+	for a := base; a < base+size; a++ {
+		if h.devs[a] != nil {
+			return ErrDevOverlap
+		}
+	}
+
+	m := &mapping{d, base}
+	for a := base; a < base+size; a++ {
+		h.devs[a] = m
+		h.cells[a].ok = false
+	}
+	if t, ok := d.(Ticker); ok {
+		h.tickers = append(h.tickers, t)
+	}
+	return nil
+}
+
+// Device returns the device mapped at addr, or nil if there is none.
+func (h *Machine) Device(addr int) Device {
+	if !inBounds(addr) || h.devs[addr] == nil {
+		return nil
+	}
+	return h.devs[addr].dev
+}
+
+// load reads the value at addr, from its device if one is mapped.
+// Device reads follow the input policy, just as GET does, and the
+// error is from input if it can't supply a value.
+func (h *Machine) load(addr int) (int, error) {
+	if m := h.devs[addr]; m != nil {
+		return h.input(func() (int, error) { return m.dev.Read(addr - m.base) })
+	}
+	return h.mem[addr], nil
+}
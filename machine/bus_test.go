@@ -0,0 +1,157 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testDevice is a device backed by a slice, logging each access.
+type testDevice struct {
+	vals []int
+	log  []string
+}
+
+func (d *testDevice) Read(off int) (int, error) {
+	d.log = append(d.log, fmt.Sprintf("r%d", off))
+	return d.vals[off], nil
+}
+
+func (d *testDevice) Write(off, v int) {
+	d.log = append(d.log, fmt.Sprintf("w%d=%d", off, v))
+	d.vals[off] = v
+}
+
+func TestMapDevice(t *testing.T) {
+	cases := []struct {
+		base, size int
+		want       error
+	}{
+		{40, 2, nil},
+		{48, 2, nil},
+		{49, 2, ErrDevRange},
+		{-1, 2, ErrDevRange},
+		{10, 0, ErrDevRange},
+		{39, 2, ErrDevOverlap},
+		{41, 1, ErrDevOverlap},
+	}
+
+	h := New()
+	for i, c := range cases {
+		if err := h.MapDevice(c.base, c.size, &testDevice{}); err != c.want {
+			t.Errorf("%02d: h.MapDevice(%d, %d) = %v; want %v", i, c.base, c.size, err, c.want)
+		}
+	}
+	if h.Device(39) != nil || h.Device(41) == nil || h.Device(41) != h.Device(40) || h.Device(50) != nil {
+		t.Errorf("h.Device() doesn't match the mapped ranges")
+	}
+}
+
+func TestDeviceAccess(t *testing.T) {
+	cases := []struct {
+		prog string
+		isa  ISA
+		want string // Output, state, PC and the device log
+	}{
+		// Reads and writes by each kind of instruction.
+		{"0: 10040\n1: 20041\n2: 11041\n3: 31041\n4: 0", ISAStandard, "[7] CPUhalt 5 [r0 r1 w1=7 r1]"},
+		{"0: 12040\n1: 13041\n2: 30040\n3: 0", ISAStandard, "[] CPUhalt 4 [r0 w1=3 w0=9]"},
+		// Values memory can't hold are bad input, and stop the CPU
+		// with CPUio before the instruction runs.
+		{"0: 12041\n1: 13010\n2: 31010\n3: 0", ISAStandard, "[] CPUio 0 [r1]"},
+		{"0: 10241\n1: 0", ISAExtended, "[] CPUio 0 [r1]"},
+		// Instructions are fetched from the memory under a device.
+		{"0: 05040", ISAStandard, "[] CPUhalt 41 []"},
+		// Pointers can be read from devices, and immediate operands
+		// don't touch them.
+		{"0: 10240\n1: 10140\n2: 0", ISAExtended, "[] CPUhalt 3 [r0]"},
+		{"0: 10140\n1: 0", ISAExtended, "[] CPUhalt 2 []"},
+	}
+
+	for i, c := range cases {
+		p, err := ParseProgram(strings.NewReader(c.prog))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := []int{}
+		h := New(WithISA(c.isa), WithInput(func() (int, error) { return 9, nil }), WithOutput(func(v int) { out = append(out, v) }), WithConsole(&strings.Builder{}))
+		h.Load(p)
+		d := &testDevice{vals: []int{3, 4}}
+		if i == 2 || i == 3 {
+			d.vals[1] = 200000
+		}
+		h.MapDevice(40, 2, d)
+		h.RunContext(context.Background(), 100)
+
+		got := fmt.Sprintf("%v %s %d %v", out, h.state, h.pc, d.log)
+		if got != c.want {
+			t.Errorf("%02d: running %q = %s; want %s", i, c.prog, got, c.want)
+		}
+		if h.mem[40] != 0 || h.mem[41] != 0 {
+			t.Errorf("%02d: memory under the device = %d, %d; want 0, 0", i, h.mem[40], h.mem[41])
+		}
+	}
+}
+
+func TestDeviceFetch(t *testing.T) {
+	// The device holds PUT 41, but the memory under it holds PUT 42,
+	// which is what runs, without reading the device.
+	d := &testDevice{vals: []int{31041, 0}}
+	var out []int
+	h := New(WithOutput(func(v int) { out = append(out, v) }), WithConsole(&strings.Builder{}))
+	h.MapDevice(40, 2, d)
+	h.mem[0], h.mem[40], h.mem[42] = 5040, 31042, 7
+	h.Run()
+	if fmt.Sprint(out) != "[7]" || h.state != CPUhalt || len(d.log) != 0 {
+		t.Errorf("Running code under a device = %s with output %v, device log %v; want CPUhalt with [7] and no device access", h.state, out, d.log)
+	}
+}
+
+func TestDeviceWatch(t *testing.T) {
+	// Writing the same value to a device still counts as a change.
+	d := &testDevice{vals: []int{0, 0}}
+	h := New(WithConsole(&strings.Builder{}))
+	h.MapDevice(40, 2, d)
+	h.mem[0], h.mem[1] = 11040, 0
+	if _, err := h.SetWatchpoint(40, WatchChange); err != nil {
+		t.Fatal(err)
+	}
+	h.Run()
+	if h.state != CPUpaused || h.pc != 1 {
+		t.Errorf("Writing to a watched device = %s at %d; want CPUpaused at 1", h.state, h.pc)
+	}
+}
+
+func TestDeviceStepBack(t *testing.T) {
+	d := &testDevice{vals: []int{3}}
+	h := New(WithHistory(), WithConsole(&strings.Builder{}))
+	h.MapDevice(40, 1, d)
+	h.mem[0], h.mem[1], h.mem[2] = 10010, 11040, 11011
+	h.mem[10] = 5
+	h.Step()
+	h.Step()
+	h.Step()
+	h.StepBack()
+	h.StepBack()
+	if d.vals[0] != 5 || h.mem[40] != 0 || h.mem[11] != 0 || h.pc != 1 {
+		t.Errorf("StepBack() over a device write = device %d, mem[40] %d, mem[11] %d, PC %d; want 5, 0, 0, 1", d.vals[0], h.mem[40], h.mem[11], h.pc)
+	}
+}
+
+func TestDeviceExplore(t *testing.T) {
+	// Each read of the device is a new input, whatever the memory
+	// under it holds, and writes to it are dropped. The device itself
+	// is never touched.
+	d := &testDevice{vals: []int{0, 0}}
+	h := New(WithConsole(&strings.Builder{}))
+	h.MapDevice(40, 2, d)
+	h.mem[0], h.mem[1], h.mem[2], h.mem[3] = 10040, 2005, 31040, 0
+	h.mem[5], h.mem[6], h.mem[7] = 11041, 31041, 0
+	h.mem[40], h.mem[41] = 5, 6
+	results, complete := h.Explore(0, 0)
+	want := "[CPUhalt at 07 with inputs [1 0], output [0] CPUhalt at 03 with inputs [0 0], output [0]]"
+	if got := fmt.Sprint(results); got != want || !complete || len(d.log) != 0 {
+		t.Errorf("h.Explore() = %s, %t with device log %v; want %s, true and no device access", got, complete, d.log, want)
+	}
+}
@@ -0,0 +1,202 @@
+// This file contains the control flow graph of a program, and its
+// export as Graphviz DOT and Mermaid diagrams.
+
+package machine
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// edge is a transfer of control into the block starting at to. The
+// label is empty for unconditional transfers.
+type edge struct {
+	to    int
+	label string
+}
+
+// block is a basic block: a run of instructions only entered at start
+// and only left after end.
+type block struct {
+	start, end int
+	succ       []edge
+}
+
+// CFG is the control flow graph of a program, made up of the basic
+// blocks reachable from address 0.
+type CFG struct {
+	prog   *Program
+	isa    ISA
+	blocks []*block // In address order
+}
+
+// CFG builds the control flow graph of the program. Like the
+// disassembler, it can't see changes a program makes to its own
+// instructions.
+func (p *Program) CFG() *CFG {
+	return p.CFGISA(ISAStandard)
+}
+
+// CFGISA is like CFG, but decodes instructions with isa. The targets of
+// indirect jumps and calls aren't known, so have no edges.
+func (p *Program) CFGISA(isa ISA) *CFG {
+	code := isa.reachable(&p.mem, 0)
+
+	// Blocks start at the entry point, at jump, call and trap handler
+	// targets and after the instructions naming them.
+	var leader [MemSize]bool
+	leader[0] = true
+	for a := range p.mem {
+		if !code[a] {
+			continue
+		}
+		i, m, cs := isa.decode(p.mem[a])
+		if cs == CPUok && m == modeDirect && (jumps(i.op) || i.op == "VEC") {
+			leader[i.addr] = true
+			if inBounds(a + 1) {
+				leader[a+1] = true
+			}
+		}
+	}
+
+	g := &CFG{prog: p, isa: isa}
+	for a := 0; a < MemSize; a++ {
+		if !code[a] {
+			continue
+		}
+		b := &block{start: a}
+		for {
+			i, m, cs := isa.decode(p.mem[a])
+			if next := modeSuccessors(a, i, m, cs); len(next) != 1 || next[0] != a+1 || leader[a+1] {
+				break
+			}
+			a++
+		}
+		b.end = a
+
+		i, m, cs := isa.decode(p.mem[a])
+		direct := cs == CPUok && m == modeDirect
+		switch {
+		case conditional(i.op) && direct:
+			rels := branchRelations[i.op]
+			b.succ = append(b.succ, edge{i.addr, fmt.Sprintf("AC %s 0", rels[0])})
+			if inBounds(a + 1) {
+				b.succ = append(b.succ, edge{a + 1, fmt.Sprintf("AC %s 0", rels[1])})
+			}
+		case i.op == "CAL" && direct:
+			b.succ = append(b.succ, edge{i.addr, "call"})
+			if inBounds(a + 1) {
+				b.succ = append(b.succ, edge{a + 1, "return"})
+			}
+		case i.op == "VEC" && direct:
+			b.succ = append(b.succ, edge{i.addr, "trap"})
+			if inBounds(a + 1) {
+				b.succ = append(b.succ, edge{a + 1, ""})
+			}
+		default:
+			for _, n := range modeSuccessors(a, i, m, cs) {
+				b.succ = append(b.succ, edge{n, ""})
+			}
+		}
+		g.blocks = append(g.blocks, b)
+	}
+	return g
+}
+
+// CFG builds the control flow graph of the machine's memory, decoded
+// with the machine's instruction set.
+func (h *Machine) CFG() *CFG {
+	return h.program().CFGISA(h.isa)
+}
+
+// lines returns the disassembly of block b, one instruction per line.
+func (g *CFG) lines(b *block) []string {
+	var l []string
+	for a := b.start; a <= b.end; a++ {
+		inst := "???"
+		if i, m, cs := g.isa.decode(g.prog.mem[a]); cs == CPUok {
+			inst = instString(i, m)
+		}
+		s := fmt.Sprintf("%02d: %s", a, inst)
+		if c := g.prog.comments[a]; c != "" {
+			s += "  // " + c
+		}
+		l = append(l, s)
+	}
+	return l
+}
+
+// faults reports whether the last instruction in b can't be executed.
+func (g *CFG) faults(b *block) bool {
+	_, _, cs := g.isa.decode(g.prog.mem[b.end])
+	return cs != CPUok
+}
+
+// dotQuote escapes s for use in a DOT string.
+func dotQuote(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+// WriteDot writes the graph to w in the Graphviz DOT language. Blocks
+// whose last instruction faults are drawn in red.
+func (g *CFG) WriteDot(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "digraph hypo {\n\tnode [shape=box fontname=monospace];\n"); err != nil {
+		return err
+	}
+	for _, b := range g.blocks {
+		label := ""
+		for _, l := range g.lines(b) {
+			label += dotQuote(l) + `\l`
+		}
+		attrs := ""
+		if g.faults(b) {
+			attrs = " color=red"
+		}
+		fmt.Fprintf(w, "\tb%02d [label=\"%s\"%s];\n", b.start, label, attrs)
+	}
+	for _, b := range g.blocks {
+		for _, e := range b.succ {
+			if e.label == "" {
+				fmt.Fprintf(w, "\tb%02d -> b%02d;\n", b.start, e.to)
+			} else {
+				fmt.Fprintf(w, "\tb%02d -> b%02d [label=\"%s\"];\n", b.start, e.to, dotQuote(e.label))
+			}
+		}
+	}
+	fmt.Fprintln(w, "}")
+	return nil
+}
+
+// mermaidQuote escapes s for use in quoted Mermaid text.
+func mermaidQuote(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;").Replace(s)
+}
+
+// WriteMermaid writes the graph to w as a Mermaid flowchart. Blocks
+// whose last instruction faults are styled in red.
+func (g *CFG) WriteMermaid(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "flowchart TD"); err != nil {
+		return err
+	}
+	for _, b := range g.blocks {
+		var lines []string
+		for _, l := range g.lines(b) {
+			lines = append(lines, mermaidQuote(l))
+		}
+		fmt.Fprintf(w, "    b%02d[\"%s\"]\n", b.start, strings.Join(lines, "<br/>"))
+		if g.faults(b) {
+			fmt.Fprintf(w, "    style b%02d stroke:red\n", b.start)
+		}
+	}
+	for _, b := range g.blocks {
+		for _, e := range b.succ {
+			if e.label == "" {
+				fmt.Fprintf(w, "    b%02d --> b%02d\n", b.start, e.to)
+			} else {
+				fmt.Fprintf(w, "    b%02d -->|\"%s\"| b%02d\n", b.start, mermaidQuote(e.label), e.to)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package machine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// blockString summarises the blocks of g, as "start-end>to:label,...".
+func blockString(g *CFG) string {
+	var s []string
+	for _, b := range g.blocks {
+		bs := fmt.Sprintf("%d-%d", b.start, b.end)
+		for _, e := range b.succ {
+			bs += fmt.Sprintf(">%d:%s", e.to, e.label)
+		}
+		s = append(s, bs)
+	}
+	return strings.Join(s, " ")
+}
+
+func TestCFG(t *testing.T) {
+	cases := []struct {
+		prog string
+		want string
+	}{
+		{"0: 0", "0-0"},
+		{"0: 31003\n1: 31003\n2: 0\n3: 7", "0-2"},
+		{"0: 10003\n1: 01000\n2: 0\n3: 7", "0-1>0:AC == 0>2:AC != 0 2-2"},
+		{"0: 05002\n1: 7\n2: 06004\n3: 05000\n4: 0", "0-0>2: 2-2>4:AC <= 0>3:AC > 0 3-3>0: 4-4"},
+		{"0: 31001\n1: 99000", "0-1"},
+		{"0: 10001\n1: 02001", "0-0>1: 1-1>1:AC > 0>2:AC <= 0 2-2"},
+		{"0: 08003\n1: 31000\n2: 0\n3: 09000", "0-0>3:call>1:return 1-2 3-3"},
+		{"0: 14003\n1: 31000\n2: 0\n3: 04000", "0-0>3:trap>1: 1-2 3-3"},
+	}
+
+	for i, c := range cases {
+		p, err := ParseProgram(strings.NewReader(c.prog))
+		if err != nil {
+			t.Fatalf("%02d: ParseProgram(%q) = %v; want nil", i, c.prog, err)
+		}
+		if got := blockString(p.CFG()); got != c.want {
+			t.Errorf("%02d: CFG(%q) = %q; want %q", i, c.prog, got, c.want)
+		}
+	}
+}
+
+func TestCFGFibonacci(t *testing.T) {
+	f, err := os.Open("../examples/fibonacci.hypo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := ParseProgram(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "0-0>1: 1-2>30:AC == 0>3:AC != 0 3-13>1: 30-30"
+	if got := blockString(p.CFG()); got != want {
+		t.Errorf("CFG(fibonacci.hypo) = %q; want %q", got, want)
+	}
+}
+
+func TestWriteDot(t *testing.T) {
+	p, err := ParseProgram(strings.NewReader("0: 10003 // Load \"x\"\n1: 01000\n2: 99000\n3: 7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := p.CFG().WriteDot(&b); err != nil {
+		t.Fatalf("WriteDot() = %v; want nil", err)
+	}
+	want := `digraph hypo {
+	node [shape=box fontname=monospace];
+	b00 [label="00: LAC 003  // Load \"x\"\l01: JEQ 000\l"];
+	b02 [label="02: ???\l" color=red];
+	b00 -> b00 [label="AC == 0"];
+	b00 -> b02 [label="AC != 0"];
+}
+`
+	if b.String() != want {
+		t.Errorf("WriteDot() = %q; want %q", b.String(), want)
+	}
+}
+
+func TestWriteMermaid(t *testing.T) {
+	p, err := ParseProgram(strings.NewReader("0: 10003 // Load \"x\"\n1: 06000\n2: 99000\n3: 7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := p.CFG().WriteMermaid(&b); err != nil {
+		t.Fatalf("WriteMermaid() = %v; want nil", err)
+	}
+	want := `flowchart TD
+    b00["00: LAC 003  // Load #quot;x#quot;<br/>01: JLE 000"]
+    b02["02: ???"]
+    style b02 stroke:red
+    b00 -->|"AC #lt;= 0"| b00
+    b00 -->|"AC #gt; 0"| b02
+`
+	if b.String() != want {
+		t.Errorf("WriteMermaid() = %q; want %q", b.String(), want)
+	}
+}
@@ -0,0 +1,62 @@
+// This file contains I/O channels: the numbered Getters and Putters
+// that GET, PUT, GCH and PCH use, selected with CHN.
+
+package machine
+
+import "fmt"
+
+// NumChannels is the number of I/O channels. Channel 0 is the one set
+// up by WithInput, WithOutput, WithCharInput and WithCharOutput, and
+// is selected when the machine starts or is reset.
+const NumChannels = 10
+
+// channel is where GET, PUT, GCH and PCH on one channel number read
+// and write. Any of them may be unattached.
+type channel struct {
+	in   Getter
+	out  Putter
+	cin  Getter // Read by GCH
+	cout Putter // Written by PCH
+}
+
+// WithChannel attaches in and out to channel n, which must be from 0
+// to NumChannels-1; New reports any other n on the console and ignores
+// the option. Either may be nil to leave that direction unattached.
+// GET or PUT on an unattached channel is an invalid memory reference.
+func WithChannel(n int, in Getter, out Putter) Option {
+	return func(h *Machine) {
+		if h.checkChannel(n) {
+			h.chans[n].in, h.chans[n].out = in, out
+		}
+	}
+}
+
+// WithCharChannel attaches in and out to channel n for GCH and PCH, as
+// WithChannel does for GET and PUT.
+func WithCharChannel(n int, in Getter, out Putter) Option {
+	return func(h *Machine) {
+		if h.checkChannel(n) {
+			h.chans[n].cin, h.chans[n].cout = in, out
+		}
+	}
+}
+
+// checkChannel reports whether an option may attach channel n, noting
+// the problem for New to report if not.
+func (h *Machine) checkChannel(n int) bool {
+	if !validChannel(n) {
+		h.optMsgs = append(h.optMsgs, fmt.Sprintf("Ignoring I/O channel %d: channels are numbered 0 to %d.", n, NumChannels-1))
+		return false
+	}
+	return true
+}
+
+// Channel returns the number of the I/O channel GET and PUT use.
+func (h *Machine) Channel() int {
+	return h.ch
+}
+
+// validChannel reports whether n is an I/O channel number.
+func validChannel(n int) bool {
+	return n >= 0 && n < NumChannels
+}
@@ -0,0 +1,132 @@
+package machine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// channelMachine returns a machine with channel 0 reading 1, channel 2
+// writing only and channel 3 reading 7 only. Values written are logged
+// to out as channel:value.
+func channelMachine(isa ISA, out *[]string) *Machine {
+	put := func(n int) Putter {
+		return func(v int) { *out = append(*out, fmt.Sprintf("%d:%d", n, v)) }
+	}
+	return New(WithISA(isa), WithInput(func() (int, error) { return 1, nil }), WithOutput(put(0)),
+		WithChannel(2, nil, put(2)), WithChannel(3, func() (int, error) { return 7, nil }, nil),
+		WithHistory(), WithConsole(&strings.Builder{}))
+}
+
+func TestChannels(t *testing.T) {
+	cases := []struct {
+		prog string
+		isa  ISA
+		want string // Output, state, PC and channel
+	}{
+		// Channel 0 is used until CHN selects another.
+		{"0: 30010\n1: 31010\n2: 0", ISAStandard, "[0:1] CPUhalt 3 0"},
+		{"0: 33010\n1: 31011\n2: 0\n10: 2\n11: 9", ISAStandard, "[2:9] CPUhalt 3 2"},
+		{"0: 33010\n1: 30011\n2: 33012\n3: 31011\n4: 0\n10: 3\n12: 2", ISAStandard, "[2:7] CPUhalt 5 2"},
+		{"0: 33102\n1: 31010\n2: 0\n10: 8", ISAExtended, "[2:8] CPUhalt 3 2"},
+		// GET and PUT fault on a channel without input or output.
+		{"0: 33010\n1: 30011\n10: 2", ISAStandard, "[] CPUbadaddr 1 2"},
+		{"0: 33010\n1: 31011\n10: 3", ISAStandard, "[] CPUbadaddr 1 3"},
+		{"0: 33010\n1: 31011\n10: 5", ISAStandard, "[] CPUbadaddr 1 5"},
+		// CHN faults on a number that isn't a channel.
+		{"0: 33010\n10: 10", ISAStandard, "[] CPUbadaddr 0 0"},
+		{"0: 33010\n10: -1", ISAStandard, "[] CPUbadaddr 0 0"},
+	}
+
+	for i, c := range cases {
+		p, err := ParseProgram(strings.NewReader(c.prog))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := []string{}
+		h := channelMachine(c.isa, &out)
+		h.Load(p)
+		h.RunContext(context.Background(), 100)
+
+		got := fmt.Sprintf("%v %s %d %d", out, h.state, h.pc, h.Channel())
+		if got != c.want {
+			t.Errorf("%02d: running %q = %s; want %s", i, c.prog, got, c.want)
+		}
+	}
+}
+
+func TestChannelOptionRange(t *testing.T) {
+	var b strings.Builder
+	put := func(v int) {}
+	h := New(WithChannel(NumChannels, nil, put), WithCharChannel(-1, nil, put), WithConsole(&b))
+	want := "Ignoring I/O channel 10: channels are numbered 0 to 9.\nIgnoring I/O channel -1: channels are numbered 0 to 9.\n"
+	if b.String() != want {
+		t.Errorf("New() with channels 10 and -1 wrote %q; want %q", b.String(), want)
+	}
+	for n := 1; n < NumChannels; n++ {
+		if c := h.chans[n]; c.out != nil || c.cout != nil {
+			t.Errorf("Channel %d is attached; want no channels but 0 attached", n)
+		}
+	}
+}
+
+func TestChannelStepBack(t *testing.T) {
+	var out []string
+	h := channelMachine(ISAStandard, &out)
+	if err := h.LoadProgram(strings.NewReader("0: 33010\n1: 30011\n2: 33012\n3: 31011\n4: 0\n10: 3\n12: 2")); err != nil {
+		t.Fatal(err)
+	}
+
+	var chans []int
+	for !h.Halted() {
+		chans = append(chans, h.ch)
+		h.Step()
+	}
+	for i := len(chans) - 1; i >= 0; i-- {
+		h.StepBack()
+		if h.ch != chans[i] {
+			t.Errorf("%02d: after StepBack channel = %d; want %d", i, h.ch, chans[i])
+		}
+	}
+
+	h.ch = 3
+	h.ResetCPU()
+	if h.ch != 0 {
+		t.Errorf("ResetCPU() left channel %d selected; want 0", h.ch)
+	}
+}
+
+func TestChannelSnapshot(t *testing.T) {
+	h := New(WithConsole(&strings.Builder{}))
+	h.ch = 4
+
+	var b bytes.Buffer
+	if err := h.SaveSnapshot(&b); err != nil {
+		t.Fatal(err)
+	}
+	g := New()
+	if err := g.LoadSnapshot(&b); err != nil || g.ch != 4 {
+		t.Errorf("g.LoadSnapshot() = %v with channel %d; want nil with channel 4", err, g.ch)
+	}
+
+	mem := "[" + strings.Repeat("0, ", MemSize-1) + "0]"
+	s := `{"version": 5, "state": "CPUok", "channel": 10, "mem": ` + mem + `}`
+	if err := g.LoadSnapshot(strings.NewReader(s)); err != ErrSnapBadData {
+		t.Errorf("g.LoadSnapshot(%q) = %v; want %v", s, err, ErrSnapBadData)
+	}
+}
+
+func TestChannelExplore(t *testing.T) {
+	// A machine's Explore uses the channels attached to it.
+	var out []string
+	h := channelMachine(ISAStandard, &out)
+	if err := h.LoadProgram(strings.NewReader("0: 33010\n1: 31011\n2: 33012\n3: 31011\n4: 0\n10: 2\n11: 6\n12: 3")); err != nil {
+		t.Fatal(err)
+	}
+	results, _ := h.Explore(0, 0)
+	if len(results) != 1 || results[0].State != CPUbadaddr || results[0].PC != 3 || fmt.Sprint(results[0].Output) != "[6]" {
+		t.Errorf("h.Explore() = %v; want one path ending in CPUbadaddr at 03 with output [6]", results)
+	}
+}
@@ -0,0 +1,152 @@
+// This file contains character I/O: the character sets that map
+// values to characters, and Getters and Putters that read and write
+// text.
+
+package machine
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+// Charset maps the values GCH and PCH use, and character Getters and
+// Putters read and write, to characters.
+type Charset int
+
+const (
+	CharsetASCII   Charset = iota // Codes 0 to 127 are ASCII
+	CharsetPETSCII                // The Commodore 64's upper and lower case set
+)
+
+func (cs Charset) String() string {
+	switch cs {
+	case CharsetASCII:
+		return "ascii"
+	case CharsetPETSCII:
+		return "petscii"
+	}
+	return "unknown"
+}
+
+// ParseCharset returns the Charset named s, as written by String.
+func ParseCharset(s string) (Charset, bool) {
+	for cs := CharsetASCII; cs <= CharsetPETSCII; cs++ {
+		if cs.String() == s {
+			return cs, true
+		}
+	}
+	return 0, false
+}
+
+// petscii holds the characters of PETSCII codes 91 to 95, which differ
+// from ASCII.
+var petscii = [...]rune{'[', '£', ']', '↑', '←'}
+
+// Rune returns the character with the given code, and whether there is
+// one. In PETSCII, 13 (RETURN) is a newline, 65 to 90 are lower case
+// letters, and both 97 to 122 and 193 to 218 are upper case letters,
+// as on a Commodore 64 switched to lower case.
+func (cs Charset) Rune(code int) (rune, bool) {
+	if cs == CharsetASCII {
+		return rune(code), code >= 0 && code < utf8.RuneSelf
+	}
+	switch {
+	case code == 13:
+		return '\n', true
+	case code >= 32 && code <= 64:
+		return rune(code), true
+	case code >= 65 && code <= 90:
+		return rune(code - 65 + 'a'), true
+	case code >= 91 && code <= 95:
+		return petscii[code-91], true
+	case code >= 97 && code <= 122:
+		return rune(code - 97 + 'A'), true
+	case code >= 193 && code <= 218:
+		return rune(code - 193 + 'A'), true
+	}
+	return 0, false
+}
+
+// Code returns the code for r, and whether r is in the character set.
+// Upper case letters are 193 to 218 in PETSCII.
+func (cs Charset) Code(r rune) (int, bool) {
+	if cs == CharsetASCII {
+		return int(r), r >= 0 && r < utf8.RuneSelf
+	}
+	switch {
+	case r == '\n':
+		return 13, true
+	case r >= ' ' && r <= '@':
+		return int(r), true
+	case r >= 'a' && r <= 'z':
+		return int(r-'a') + 65, true
+	case r >= 'A' && r <= 'Z':
+		return int(r-'A') + 193, true
+	}
+	for i, p := range petscii {
+		if p == r {
+			return 91 + i, true
+		}
+	}
+	return 0, false
+}
+
+// CharGetter returns a Getter that reads a character from r and
+// returns its code in cs. Characters that aren't in cs read as the code
+// for '?'. At the end of r it returns io.EOF.
+func CharGetter(r io.RuneReader, cs Charset) Getter {
+	return func() (int, error) {
+		c, _, err := r.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+		if code, ok := cs.Code(c); ok {
+			return code, nil
+		}
+		code, _ := cs.Code('?')
+		return code, nil
+	}
+}
+
+// CharPutter returns a Putter that writes the character with each
+// value's code in cs to w. Values that aren't codes are written as '?'.
+func CharPutter(w io.Writer, cs Charset) Putter {
+	return func(v int) {
+		c, ok := cs.Rune(v)
+		if !ok {
+			c = '?'
+		}
+		fmt.Fprint(w, string(c))
+	}
+}
+
+// stdin reads runes from the standard input a byte at a time, so that
+// nothing beyond each rune is consumed.
+type stdin struct{}
+
+func (stdin) ReadRune() (rune, int, error) {
+	var b [utf8.UTFMax]byte
+	for n := 1; n <= len(b); n++ {
+		if _, err := os.Stdin.Read(b[n-1 : n]); err != nil {
+			return 0, 0, err
+		}
+		if utf8.FullRune(b[:n]) {
+			r, size := utf8.DecodeRune(b[:n])
+			return r, size, nil
+		}
+	}
+	return utf8.RuneError, len(b), nil
+}
+
+// Stdin reads runes from the standard input without reading ahead, so
+// it can be shared with Input.
+var Stdin io.RuneReader = stdin{}
+
+// The default character Getter and Putter, reading from Stdin and
+// writing to stdout in ASCII.
+var (
+	CharInput  = CharGetter(Stdin, CharsetASCII)
+	CharOutput = CharPutter(os.Stdout, CharsetASCII)
+)
@@ -0,0 +1,150 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseCharset(t *testing.T) {
+	for cs := CharsetASCII; cs <= CharsetPETSCII; cs++ {
+		if got, ok := ParseCharset(cs.String()); !ok || got != cs {
+			t.Errorf("ParseCharset(%q) = %s, %t; want %s, true", cs, got, ok, cs)
+		}
+	}
+	if _, ok := ParseCharset("ebcdic"); ok {
+		t.Errorf("ParseCharset(\"ebcdic\") = _, true; want false")
+	}
+}
+
+func TestCharset(t *testing.T) {
+	cases := []struct {
+		cs   Charset
+		code int
+		r    rune
+		ok   bool
+	}{
+		{CharsetASCII, 72, 'H', true},
+		{CharsetASCII, 104, 'h', true},
+		{CharsetASCII, 10, '\n', true},
+		{CharsetASCII, 128, 0, false},
+		{CharsetASCII, -1, 0, false},
+		{CharsetPETSCII, 13, '\n', true},
+		{CharsetPETSCII, 33, '!', true},
+		{CharsetPETSCII, 72, 'h', true},
+		{CharsetPETSCII, 200, 'H', true},
+		{CharsetPETSCII, 92, '£', true},
+		{CharsetPETSCII, 94, '↑', true},
+		{CharsetPETSCII, 10, 0, false},
+		{CharsetPETSCII, 150, 0, false},
+	}
+
+	for i, c := range cases {
+		r, ok := c.cs.Rune(c.code)
+		if ok != c.ok || (ok && r != c.r) {
+			t.Errorf("%02d: %s.Rune(%d) = %q, %t; want %q, %t", i, c.cs, c.code, r, ok, c.r, c.ok)
+		}
+		if !c.ok {
+			continue
+		}
+		if code, ok := c.cs.Code(c.r); !ok || code != c.code {
+			t.Errorf("%02d: %s.Code(%q) = %d, %t; want %d, true", i, c.cs, c.r, code, ok, c.code)
+		}
+	}
+
+	// The second set of PETSCII upper case letters reads the same, but
+	// isn't written.
+	if r, _ := CharsetPETSCII.Rune(104); r != 'H' {
+		t.Errorf("CharsetPETSCII.Rune(104) = %q; want 'H'", r)
+	}
+	for _, cs := range []Charset{CharsetASCII, CharsetPETSCII} {
+		if code, ok := cs.Code('é'); ok {
+			t.Errorf("%s.Code('é') = %d, true; want false", cs, code)
+		}
+	}
+}
+
+func TestCharGetterPutter(t *testing.T) {
+	g := CharGetter(strings.NewReader("Hi£\n"), CharsetPETSCII)
+	var got []int
+	for i := 0; i < 4; i++ {
+		v, err := g()
+		if err != nil {
+			t.Fatalf("Reading \"Hi£\\n\" in PETSCII = %v; want nil", err)
+		}
+		got = append(got, v)
+	}
+	if fmt.Sprint(got) != "[200 73 92 13]" {
+		t.Errorf("Reading \"Hi£\\n\" in PETSCII = %v; want [200 73 92 13]", got)
+	}
+	if _, err := g(); err != io.EOF {
+		t.Errorf("Reading past the end = %v; want %v", err, io.EOF)
+	}
+	if v, _ := CharGetter(strings.NewReader("£"), CharsetASCII)(); v != '?' {
+		t.Errorf("Reading \"£\" in ASCII = %d; want %d", v, '?')
+	}
+
+	var b strings.Builder
+	p := CharPutter(&b, CharsetPETSCII)
+	for _, v := range []int{200, 73, 33, 13, 500} {
+		p(v)
+	}
+	if b.String() != "Hi!\n?" {
+		t.Errorf("Writing in PETSCII = %q; want \"Hi!\\n?\"", b.String())
+	}
+}
+
+func TestCharIO(t *testing.T) {
+	cases := []struct {
+		prog string
+		want string // Output, state and PC
+	}{
+		// Copy a character to a character and a number.
+		{"0: 34010\n1: 35010\n2: 31010\n3: 0", "[c72 n72] CPUhalt 4"},
+		// Characters use the selected channel, and fault where there are
+		// none.
+		{"0: 33011\n1: 35012\n2: 0\n11: 1\n12: 33", "[c1:33] CPUhalt 3"},
+		{"0: 33011\n1: 35012\n2: 0\n11: 2\n12: 33", "[] CPUbadaddr 1"},
+		{"0: 33011\n1: 34012\n2: 0\n11: 1", "[] CPUbadaddr 1"},
+	}
+
+	for i, c := range cases {
+		p, err := ParseProgram(strings.NewReader(c.prog))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := []string{}
+		h := New(WithInput(func() (int, error) { return 0, nil }), WithOutput(func(v int) { out = append(out, fmt.Sprintf("n%d", v)) }),
+			WithCharInput(CharGetter(strings.NewReader("H"), CharsetASCII)), WithCharOutput(func(v int) { out = append(out, fmt.Sprintf("c%d", v)) }),
+			WithCharChannel(1, nil, func(v int) { out = append(out, fmt.Sprintf("c1:%d", v)) }),
+			WithConsole(&strings.Builder{}))
+		h.Load(p)
+		h.RunContext(context.Background(), 100)
+
+		got := fmt.Sprintf("%v %s %d", out, h.state, h.pc)
+		if got != c.want {
+			t.Errorf("%02d: running %q = %s; want %s", i, c.prog, got, c.want)
+		}
+	}
+}
+
+func TestHelloExample(t *testing.T) {
+	f, err := os.Open("../examples/hello.hypo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var b strings.Builder
+	h := New(WithCharOutput(CharPutter(&b, CharsetASCII)), WithConsole(&strings.Builder{}))
+	if err := h.LoadProgram(f); err != nil {
+		t.Fatal(err)
+	}
+	h.Run()
+	if h.state != CPUhalt || b.String() != "Hello, world!\n" {
+		t.Errorf("Running hello.hypo = %s writing %q; want CPUhalt writing \"Hello, world!\\n\"", h.state, b.String())
+	}
+}
@@ -0,0 +1,650 @@
+// This file contains the compiler for hypol, a tiny structured
+// language. Programs are translated to assembler source, which the
+// assembler then lays out in memory.
+
+package machine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Errors returned, wrapped with the source line, by Compile.
+var (
+	ErrCompSyntax     = errors.New("Syntax error")
+	ErrCompUndefined  = errors.New("Undefined name")
+	ErrCompRedeclared = errors.New("Name declared more than once")
+	ErrCompConstant   = errors.New("Can't assign to a constant")
+	ErrCompRange      = errors.New("Number out of range")
+	ErrCompTooBig     = errors.New("Program doesn't fit in memory")
+)
+
+// keywords can't be used as names.
+var keywords = map[string]bool{"var": true, "const": true, "if": true, "else": true, "while": true, "input": true, "print": true}
+
+// relops are the comparison operators.
+var relops = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// isLetter reports whether b can start a name. Names are limited to
+// ASCII so that they are also valid assembler labels, and can't start
+// with an underscore, which is kept for the compiler's own labels.
+func isLetter(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// internalLabels matches the compiler's own labels, which the
+// assembler adds to the start of comments.
+var internalLabels = regexp.MustCompile(`^(_[A-Za-z0-9_]*: ?)+`)
+
+// token is a lexical element of hypol source.
+type token struct {
+	line int
+	kind string // ident, num, punct or eof
+	text string
+}
+
+// lex splits src into tokens. Comments run from // to the end of the
+// line.
+func lex(src string) ([]token, error) {
+	var toks []token
+	line := 1
+	for i := 0; i < len(src); {
+		switch {
+		case src[i] == '\n':
+			line++
+			i++
+		case strings.ContainsRune(" \t\r", rune(src[i])):
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case isLetter(src[i]):
+			j := i
+			for j < len(src) && (isLetter(src[j]) || isDigit(src[j]) || src[j] == '_') {
+				j++
+			}
+			toks = append(toks, token{line, "ident", src[i:j]})
+			i = j
+		case isDigit(src[i]):
+			j := i
+			for j < len(src) && isDigit(src[j]) {
+				j++
+			}
+			toks = append(toks, token{line, "num", src[i:j]})
+			i = j
+		default:
+			p := src[i : i+1]
+			if i+1 < len(src) && relops[src[i:i+2]] {
+				p = src[i : i+2]
+			}
+			if !relops[p] && !strings.Contains("+-*/%(){}=;,", p) {
+				return nil, fmt.Errorf("line %d: %q: %w", line, p, ErrCompSyntax)
+			}
+			toks = append(toks, token{line, "punct", p})
+			i += len(p)
+		}
+	}
+	return append(toks, token{line, "eof", ""}), nil
+}
+
+// expr is a node in an expression tree. Leaves are numbers (op num)
+// and variables (op var); other nodes apply op to l and, for binary
+// operators, r.
+type expr struct {
+	op   string
+	val  int
+	name string
+	l, r *expr
+}
+
+// compiler holds the state of a compilation.
+type compiler struct {
+	toks []token
+	pos  int
+
+	src    []string       // Source lines, for comments
+	vars   []string       // Variables in declaration order
+	varAt  map[string]int // The line each variable was declared on
+	consts map[string]int // Named constants and their values
+	lits   []int          // Literals used, in order of first use
+	litSet map[int]bool
+
+	temps, maxTemps int // Temporaries in use, and the most ever needed
+	labels          int // Code labels allocated
+
+	asm     []string // Generated assembler source
+	asmLine []int    // The hypol line each assembler line came from
+	words   int      // Instructions generated
+	stmt    int      // The line of the statement being compiled
+	comment string   // The comment for the next instruction
+}
+
+// Compile translates hypol source read from r into a program. The
+// language has integer variables, named constants, arithmetic, if,
+// while, input and print; see README.md for details. Arithmetic
+// saturates just as the machine's does. Source line numbers are kept,
+// so coverage and profiles refer to the hypol source. Errors wrap one
+// of the ErrComp values.
+func Compile(r io.Reader) (*Program, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	toks, err := lex(string(b))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &compiler{
+		toks:   toks,
+		src:    strings.Split(string(b), "\n"),
+		varAt:  map[string]int{},
+		consts: map[string]int{},
+		litSet: map[int]bool{},
+	}
+	for c.peek().kind != "eof" {
+		if err := c.statement(); err != nil {
+			return nil, err
+		}
+	}
+	c.stmt = c.peek().line
+	c.emit("HLT", "")
+
+	if err := c.checkSize(); err != nil {
+		return nil, err
+	}
+	c.data()
+
+	p, err := Assemble(strings.NewReader(strings.Join(c.asm, "\n")))
+	if err != nil {
+		// The generated source should always assemble.
+		return nil, fmt.Errorf("internal compiler error: %v", err)
+	}
+	for a, l := range p.lines {
+		if p.used[a] {
+			p.lines[a] = c.asmLine[l-1]
+			p.comments[a] = internalLabels.ReplaceAllString(p.comments[a], "")
+		}
+	}
+	return p, nil
+}
+
+func (c *compiler) peek() token {
+	return c.toks[c.pos]
+}
+
+func (c *compiler) next() token {
+	t := c.toks[c.pos]
+	if t.kind != "eof" {
+		c.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if its text is s.
+func (c *compiler) accept(s string) bool {
+	if t := c.peek(); t.kind != "num" && t.text == s {
+		c.pos++
+		return true
+	}
+	return false
+}
+
+// errorf returns an error about token t.
+func errorf(t token, err error) error {
+	text := t.text
+	if t.kind == "eof" {
+		text = "end of file"
+	}
+	return fmt.Errorf("line %d: %q: %w", t.line, text, err)
+}
+
+// expect consumes the next token, which must be s.
+func (c *compiler) expect(s string) error {
+	if !c.accept(s) {
+		return errorf(c.peek(), ErrCompSyntax)
+	}
+	return nil
+}
+
+// ident consumes a name that isn't a keyword.
+func (c *compiler) ident() (token, error) {
+	t := c.next()
+	if t.kind != "ident" || keywords[t.text] {
+		return t, errorf(t, ErrCompSyntax)
+	}
+	return t, nil
+}
+
+// variable consumes the name of a declared variable.
+func (c *compiler) variable() (string, error) {
+	t, err := c.ident()
+	if err != nil {
+		return "", err
+	}
+	if _, ok := c.consts[t.text]; ok {
+		return "", errorf(t, ErrCompConstant)
+	}
+	if _, ok := c.varAt[t.text]; !ok {
+		return "", errorf(t, ErrCompUndefined)
+	}
+	return t.text, nil
+}
+
+// declare records a new name, which must not already be in use.
+func (c *compiler) declare(t token) error {
+	_, isVar := c.varAt[t.text]
+	_, isConst := c.consts[t.text]
+	if isVar || isConst {
+		return errorf(t, ErrCompRedeclared)
+	}
+	return nil
+}
+
+// number converts the text of a number token, negated if neg is set.
+func number(t token, neg bool) (int, error) {
+	v, err := strconv.Atoi(t.text)
+	if neg {
+		v = -v
+	}
+	if err != nil || v != boundsCap(v) {
+		return 0, errorf(t, ErrCompRange)
+	}
+	return v, nil
+}
+
+func (c *compiler) statement() error {
+	defer func(outer int) { c.stmt, c.comment = outer, "" }(c.stmt)
+	if c.peek().line != c.stmt {
+		// Statements nested on the same line share its comment.
+		c.stmt = c.peek().line
+		c.comment = strings.TrimSpace(c.src[c.stmt-1])
+	}
+	switch t := c.next(); t.text {
+	case "var":
+		for {
+			v, err := c.ident()
+			if err != nil {
+				return err
+			}
+			if err := c.declare(v); err != nil {
+				return err
+			}
+			c.vars = append(c.vars, v.text)
+			c.varAt[v.text] = v.line
+			if !c.accept(",") {
+				break
+			}
+		}
+		return c.expect(";")
+
+	case "const":
+		k, err := c.ident()
+		if err != nil {
+			return err
+		}
+		if err := c.declare(k); err != nil {
+			return err
+		}
+		if err := c.expect("="); err != nil {
+			return err
+		}
+		neg := c.accept("-")
+		n := c.next()
+		if n.kind != "num" {
+			return errorf(n, ErrCompSyntax)
+		}
+		v, err := number(n, neg)
+		if err != nil {
+			return err
+		}
+		c.consts[k.text] = v
+		return c.expect(";")
+
+	case "input":
+		v, err := c.variable()
+		if err != nil {
+			return err
+		}
+		c.emit("GET", v)
+		return c.expect(";")
+
+	case "print":
+		e, err := c.expr()
+		if err != nil {
+			return err
+		}
+		o := c.operand(e)
+		c.emit("PUT", o)
+		c.release(o)
+		return c.expect(";")
+
+	case "if":
+		skip, err := c.cond()
+		if err != nil {
+			return err
+		}
+		if err := c.block(); err != nil {
+			return err
+		}
+		if !c.accept("else") {
+			c.label(skip)
+			return nil
+		}
+		end := c.newLabel()
+		c.emit("JMP", end)
+		c.label(skip)
+		if c.peek().text == "if" {
+			err = c.statement()
+		} else {
+			err = c.block()
+		}
+		c.label(end)
+		return err
+
+	case "while":
+		top := c.newLabel()
+		c.label(top)
+		end, err := c.cond()
+		if err != nil {
+			return err
+		}
+		if err := c.block(); err != nil {
+			return err
+		}
+		c.emit("JMP", top)
+		c.label(end)
+		return nil
+
+	default:
+		c.pos--
+		v, err := c.variable()
+		if err != nil {
+			return err
+		}
+		if err := c.expect("="); err != nil {
+			return err
+		}
+		e, err := c.expr()
+		if err != nil {
+			return err
+		}
+		c.load(e)
+		c.emit("PAC", v)
+		return c.expect(";")
+	}
+}
+
+// block compiles a braced list of statements.
+func (c *compiler) block() error {
+	if err := c.expect("{"); err != nil {
+		return err
+	}
+	for !c.accept("}") {
+		if c.peek().kind == "eof" {
+			return errorf(c.peek(), ErrCompSyntax)
+		}
+		if err := c.statement(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cond compiles a comparison, emitting a jump that is taken when it
+// is false. It returns the label the jump targets. There is no jump
+// on AC >= 0, so the comparison is made in whichever order lets a
+// single jump test it.
+func (c *compiler) cond() (string, error) {
+	l, err := c.expr()
+	if err != nil {
+		return "", err
+	}
+	t := c.next()
+	r, err := c.expr()
+	if err != nil {
+		return "", err
+	}
+
+	var d *expr
+	var jump string
+	switch t.text {
+	case "==":
+		d, jump = &expr{op: "-", l: l, r: r}, "JNE"
+	case "!=":
+		d, jump = &expr{op: "-", l: l, r: r}, "JEQ"
+	case "<":
+		d, jump = &expr{op: "-", l: r, r: l}, "JLE"
+	case ">":
+		d, jump = &expr{op: "-", l: l, r: r}, "JLE"
+	case "<=":
+		d, jump = &expr{op: "-", l: l, r: r}, "JGT"
+	case ">=":
+		d, jump = &expr{op: "-", l: r, r: l}, "JGT"
+	default:
+		return "", errorf(t, ErrCompSyntax)
+	}
+
+	// Saturation never changes the sign of a difference, or makes a
+	// non-zero one zero, so comparing it against zero is safe.
+	c.load(d)
+	skip := c.newLabel()
+	c.emit(jump, skip)
+	return skip, nil
+}
+
+// expr parses an expression: terms separated by + and -.
+func (c *compiler) expr() (*expr, error) {
+	e, err := c.term()
+	for err == nil && (c.peek().text == "+" || c.peek().text == "-") {
+		op := c.next().text
+		var r *expr
+		r, err = c.term()
+		e = &expr{op: op, l: e, r: r}
+	}
+	return e, err
+}
+
+// term parses factors separated by *, / and %.
+func (c *compiler) term() (*expr, error) {
+	e, err := c.factor()
+	for err == nil && strings.Contains("*/%", c.peek().text) && c.peek().kind == "punct" {
+		op := c.next().text
+		var r *expr
+		r, err = c.factor()
+		e = &expr{op: op, l: e, r: r}
+	}
+	return e, err
+}
+
+// factor parses a number, name, negation or parenthesised expression.
+func (c *compiler) factor() (*expr, error) {
+	t := c.next()
+	switch {
+	case t.kind == "num":
+		v, err := number(t, false)
+		if err != nil {
+			return nil, err
+		}
+		return c.literal(v), nil
+	case t.text == "-":
+		if n := c.peek(); n.kind == "num" {
+			c.next()
+			v, err := number(n, true)
+			if err != nil {
+				return nil, err
+			}
+			return c.literal(v), nil
+		}
+		e, err := c.factor()
+		return &expr{op: "neg", l: e}, err
+	case t.text == "(":
+		e, err := c.expr()
+		if err != nil {
+			return nil, err
+		}
+		return e, c.expect(")")
+	case t.kind == "ident" && !keywords[t.text]:
+		if v, ok := c.consts[t.text]; ok {
+			return c.literal(v), nil
+		}
+		if _, ok := c.varAt[t.text]; !ok {
+			return nil, errorf(t, ErrCompUndefined)
+		}
+		return &expr{op: "var", name: t.text}, nil
+	}
+	return nil, errorf(t, ErrCompSyntax)
+}
+
+// literal returns a leaf for the number v, adding it to the pool of
+// constants stored in memory.
+func (c *compiler) literal(v int) *expr {
+	if !c.litSet[v] {
+		c.litSet[v] = true
+		c.lits = append(c.lits, v)
+	}
+	return &expr{op: "num", val: v}
+}
+
+// litLabel returns the label of the cell holding the literal v.
+func litLabel(v int) string {
+	if v < 0 {
+		return fmt.Sprintf("_km%d", -v)
+	}
+	return fmt.Sprintf("_k%d", v)
+}
+
+// operand returns the label of a cell holding the value of e,
+// computing it into a temporary if it isn't a leaf. The caller should
+// release the result once it's used.
+func (c *compiler) operand(e *expr) string {
+	switch e.op {
+	case "num":
+		return litLabel(e.val)
+	case "var":
+		return e.name
+	}
+	c.load(e)
+	t := c.temp()
+	c.emit("PAC", t)
+	return t
+}
+
+// temp allocates a temporary cell.
+func (c *compiler) temp() string {
+	t := fmt.Sprintf("_t%d", c.temps)
+	c.temps++
+	if c.temps > c.maxTemps {
+		c.maxTemps = c.temps
+	}
+	return t
+}
+
+// release frees o if it is a temporary. Temporaries are released in
+// the reverse of the order they were allocated in.
+func (c *compiler) release(o string) {
+	if strings.HasPrefix(o, "_t") {
+		c.temps--
+	}
+}
+
+// load emits code leaving the value of e in AC. The right operand of
+// a binary operator is computed first, so that the left can be
+// computed straight into AC. Multiplication and division use MQ: MUL
+// leaves the product there, and DIV leaves the quotient there and the
+// remainder in AC.
+func (c *compiler) load(e *expr) {
+	switch e.op {
+	case "num", "var":
+		c.emit("LAC", c.operand(e))
+	case "neg":
+		o := c.operand(e.l)
+		c.emit("LAC", c.operand(c.literal(0)))
+		c.emit("SUB", o)
+		c.release(o)
+	case "+", "-":
+		o := c.operand(e.r)
+		c.load(e.l)
+		c.emit(map[string]string{"+": "ADD", "-": "SUB"}[e.op], o)
+		c.release(o)
+	case "*", "/", "%":
+		l := c.operand(e.l)
+		r := c.operand(e.r)
+		c.emit("LMQ", l)
+		if e.op == "*" {
+			c.emit("MUL", r)
+		} else {
+			c.emit("DIV", r)
+		}
+		c.release(r)
+		c.release(l)
+		if e.op != "%" {
+			t := c.temp()
+			c.emit("PMQ", t)
+			c.emit("LAC", t)
+			c.release(t)
+		}
+	}
+}
+
+// newLabel allocates a code label.
+func (c *compiler) newLabel() string {
+	c.labels++
+	return fmt.Sprintf("_L%d", c.labels)
+}
+
+// label places l at the next instruction.
+func (c *compiler) label(l string) {
+	c.asm = append(c.asm, l+":")
+	c.asmLine = append(c.asmLine, c.stmt)
+}
+
+// emit appends an instruction. The first instruction of each
+// statement is commented with the statement's source line.
+func (c *compiler) emit(op, operand string) {
+	s := "\t" + op + " " + operand
+	if c.comment != "" {
+		s += "\t// " + c.comment
+		c.comment = ""
+	}
+	c.asm = append(c.asm, s)
+	c.asmLine = append(c.asmLine, c.stmt)
+	c.words++
+}
+
+// checkSize reports an error if the code and data won't fit in
+// memory.
+func (c *compiler) checkSize() error {
+	need := c.words + len(c.vars) + len(c.lits) + c.maxTemps
+	if need > MemSize {
+		return fmt.Errorf("%w: needs %d cells (%d instructions, %d variables, %d constants and %d temporaries) but there are only %d",
+			ErrCompTooBig, need, c.words, len(c.vars), len(c.lits), c.maxTemps, MemSize)
+	}
+	return nil
+}
+
+// data appends the cells for variables, constants and temporaries.
+func (c *compiler) data() {
+	c.stmt = 0
+	for _, v := range c.vars {
+		c.asm = append(c.asm, fmt.Sprintf("%s:\tDAT 0\t// variable %s", v, v))
+		c.asmLine = append(c.asmLine, c.varAt[v])
+	}
+	for _, v := range c.lits {
+		c.asm = append(c.asm, fmt.Sprintf("%s:\tDAT %d\t// constant %d", litLabel(v), v, v))
+		c.asmLine = append(c.asmLine, 0)
+	}
+	for t := 0; t < c.maxTemps; t++ {
+		c.asm = append(c.asm, fmt.Sprintf("_t%d:\tDAT 0\t// temporary", t))
+		c.asmLine = append(c.asmLine, 0)
+	}
+}
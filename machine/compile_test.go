@@ -0,0 +1,134 @@
+package machine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// runCompiled compiles src and runs it with the given input, returning
+// the output and the final state.
+func runCompiled(t *testing.T, src string, input []int) ([]int, CPUState) {
+	t.Helper()
+	p, err := Compile(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("Compile(%q) = %v; want nil", src, err)
+	}
+	var out []int
+	h := New(WithInput(func() (int, error) { v := input[0]; input = input[1:]; return v, nil }), WithOutput(func(i int) { out = append(out, i) }), WithConsole(&strings.Builder{}))
+	h.Load(p)
+	h.RunContext(context.Background(), 100000)
+	return out, h.State()
+}
+
+func TestCompile(t *testing.T) {
+	cases := []struct {
+		src   string
+		input []int
+		want  []int
+	}{
+		{"print 42;", nil, []int{42}},
+		{"print -7;", nil, []int{-7}},
+		{"var x; input x; print -x;", []int{5}, []int{-5}},
+		{"const N = 10; print N * 2 + 1;", nil, []int{21}},
+		{"print 2 + 3 * 4;", nil, []int{14}},
+		{"print (2 + 3) * 4;", nil, []int{20}},
+		{"print 10 - 4 - 3;", nil, []int{3}},
+		{"print 17 / 5; print 17 % 5; print -17 % 5;", nil, []int{3, 2, -2}},
+		{"print (1 + 2) * (3 + 4) - (5 + 6) * (7 - 8);", nil, []int{32}},
+		{"print 99999 + 1;", nil, []int{99999}},
+		{"var a, b; input a; input b; if a > b { print a; } else { print b; }", []int{3, 8}, []int{8}},
+		{"var a, b; input a; input b; if a > b { print a; } else { print b; }", []int{9, 8}, []int{9}},
+		{"var x; input x; if x < 0 { print -1; } else if x == 0 { print 0; } else { print 1; }", []int{0}, []int{0}},
+		{"var x; input x; if x < 0 { print -1; } else if x == 0 { print 0; } else { print 1; }", []int{-4}, []int{-1}},
+		{"var x; input x; if x < 0 { print -1; } else if x == 0 { print 0; } else { print 1; }", []int{4}, []int{1}},
+		{"var i; i = 0; while i < 3 { print i; i = i + 1; }", nil, []int{0, 1, 2}},
+		{"var i; i = 3; while i >= 1 { print i; i = i - 1; }", nil, []int{3, 2, 1}},
+		{"var i; i = 1; while i <= 8 { i = i * 2; } print i;", nil, []int{16}},
+		{"var i; i = 1; while i != 5 { i = i + 1; } print i;", nil, []int{5}},
+		{"// comment\nvar n; // trailing\nn = 4; print n;", nil, []int{4}},
+	}
+
+	for i, c := range cases {
+		out, state := runCompiled(t, c.src, c.input)
+		if state != CPUhalt || fmt.Sprint(out) != fmt.Sprint(c.want) {
+			t.Errorf("%02d: running %q = %v, %s; want %v, CPUhalt", i, c.src, out, state, c.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	long := "var x;" + strings.Repeat(" x = x + 1;", 20)
+	cases := []struct {
+		src  string
+		want error
+	}{
+		{"print 1", ErrCompSyntax},
+		{"print 1 +;", ErrCompSyntax},
+		{"var if;", ErrCompSyntax},
+		{"var _x;", ErrCompSyntax},
+		{"print #;", ErrCompSyntax},
+		{"if 1 { print 1;", ErrCompSyntax},
+		{"if 1 { print 1; }", ErrCompSyntax},
+		{"print x;", ErrCompUndefined},
+		{"x = 1;", ErrCompUndefined},
+		{"var x, x;", ErrCompRedeclared},
+		{"const x = 1; var x;", ErrCompRedeclared},
+		{"const x = 1; x = 2;", ErrCompConstant},
+		{"const x = 1; input x;", ErrCompConstant},
+		{"print 100000;", ErrCompRange},
+		{"const x = -100000;", ErrCompRange},
+		{long, ErrCompTooBig},
+	}
+
+	for i, c := range cases {
+		if _, err := Compile(strings.NewReader(c.src)); !errors.Is(err, c.want) {
+			t.Errorf("%02d: Compile(%q) = %v; want %v", i, c.src, err, c.want)
+		}
+	}
+}
+
+func TestCompileLines(t *testing.T) {
+	p, err := Compile(strings.NewReader("var x;\ninput x;\n\nprint x + 1;\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if _, err := p.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `00: 30006 // input x;
+01: 10006 // print x + 1;
+02: 20007
+03: 11008
+04: 31008
+05: 00000
+06: 00000 // x: variable x
+07: 00001 // constant 1
+08: 00000 // temporary
+`
+	if b.String() != want {
+		t.Errorf("WriteTo() = %q; want %q", b.String(), want)
+	}
+
+	for a, want := range []int{2, 4, 4, 4, 4, 5, 1} {
+		if p.lines[a] != want {
+			t.Errorf("lines[%d] = %d; want %d", a, p.lines[a], want)
+		}
+	}
+}
+
+func TestCompileGCD(t *testing.T) {
+	src, err := os.ReadFile("../examples/gcd.hyl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, state := runCompiled(t, string(src), []int{12, 18, -35, 14, 7, 0, 0})
+	if want := []int{6, 7, 7}; state != CPUhalt || fmt.Sprint(out) != fmt.Sprint(want) {
+		t.Errorf("gcd.hyl = %v, %s; want %v, CPUhalt", out, state, want)
+	}
+}
@@ -0,0 +1,217 @@
+// This file contains code coverage collection and reporting.
+
+package machine
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Coverage records which memory cells a machine executed as
+// instructions and which ways each conditional jump went, so they can
+// be reported against the source lines of the loaded program.
+type Coverage struct {
+	prog     *Program      // The program being covered
+	code     [MemSize]bool // Cells expected to run as instructions
+	branch   [MemSize]bool // Cells holding a conditional jump
+	count    [MemSize]int  // Executions per address
+	taken    [MemSize]int  // Conditional jumps taken, per address
+	notTaken [MemSize]int  // Conditional jumps not taken, per address
+}
+
+// add records the execution of instruction i at addr, with the
+// accumulator holding ac beforehand.
+func (c *Coverage) add(addr int, i Instruction, ac int) {
+	c.code[addr] = true
+	c.count[addr]++
+	if conditional(i.op) {
+		c.branch[addr] = true
+		if jumpTaken(i.op, ac) {
+			c.taken[addr]++
+		} else {
+			c.notTaken[addr]++
+		}
+	}
+}
+
+// StartCoverage starts collecting coverage of the loaded program,
+// discarding any coverage already being collected. Cells reachable
+// from address 0 are expected to run; others only count once they
+// do. The returned coverage is updated as the machine runs.
+func (h *Machine) StartCoverage() *Coverage {
+	p := h.prog
+	if p == nil {
+		p = h.program()
+	}
+
+	c := &Coverage{prog: p}
+	code := h.isa.reachable(&h.mem, 0)
+	for a, v := range h.mem {
+		if !code[a] || !p.used[a] {
+			continue
+		}
+		c.code[a] = true
+		i, _, _ := h.isa.decode(v)
+		c.branch[a] = conditional(i.op)
+	}
+	h.cover = c
+	return c
+}
+
+// StopCoverage stops collecting coverage and returns the result, or
+// nil if coverage wasn't being collected.
+func (h *Machine) StopCoverage() *Coverage {
+	c := h.cover
+	h.cover = nil
+	return c
+}
+
+// Executed returns the number of times the cell at addr was executed.
+func (c *Coverage) Executed(addr int) int {
+	if !inBounds(addr) {
+		return 0
+	}
+	return c.count[addr]
+}
+
+// Branches returns the number of times the conditional jump at addr
+// was taken and not taken.
+func (c *Coverage) Branches(addr int) (taken, notTaken int) {
+	if !inBounds(addr) {
+		return 0, 0
+	}
+	return c.taken[addr], c.notTaken[addr]
+}
+
+// Summary returns the number of code cells and how many of them were
+// executed, along with the number of branch directions (two per
+// conditional jump) and how many of them were followed.
+func (c *Coverage) Summary() (cells, cellsHit, branches, branchesHit int) {
+	for a := range c.code {
+		if !c.code[a] {
+			continue
+		}
+		cells++
+		if c.count[a] > 0 {
+			cellsHit++
+		}
+		if c.branch[a] {
+			branches += 2
+			if c.taken[a] > 0 {
+				branchesHit++
+			}
+			if c.notTaken[a] > 0 {
+				branchesHit++
+			}
+		}
+	}
+	return cells, cellsHit, branches, branchesHit
+}
+
+// percent returns n as a percentage of total.
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// Report writes a listing of the code cells to w, showing the source
+// line each came from, how often it ran and, for conditional jumps,
+// how often each way was followed. Cells that never ran are marked
+// with "!".
+func (c *Coverage) Report(w io.Writer) error {
+	cells, cellsHit, branches, branchesHit := c.Summary()
+	if _, err := fmt.Fprintf(w, "Cells executed: %d/%d (%.1f%%)\nBranches followed: %d/%d (%.1f%%)\n\n",
+		cellsHit, cells, percent(cellsHit, cells), branchesHit, branches, percent(branchesHit, branches)); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "  Line  Addr     Count  Instruction  Taken  Not taken  Comment\n")
+	for a := range c.code {
+		if !c.code[a] {
+			continue
+		}
+
+		line := "-"
+		if c.prog.lines[a] != 0 {
+			line = fmt.Sprint(c.prog.lines[a])
+		}
+		mark := " "
+		if c.count[a] == 0 {
+			mark = "!"
+		}
+		branches := fmt.Sprintf("%5s  %9s", "", "")
+		if c.branch[a] {
+			branches = fmt.Sprintf("%5d  %9d", c.taken[a], c.notTaken[a])
+		}
+		i, _ := Decode(c.prog.mem[a])
+		if _, err := fmt.Fprintf(w, "%s%5s  %02d  %8d  %-11s  %s  %s\n", mark, line, a, c.count[a], i, branches, c.prog.comments[a]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteLcov writes the coverage to w as an lcov tracefile for the
+// source file named source. Cells without a known source line are
+// left out.
+func (c *Coverage) WriteLcov(w io.Writer, source string) error {
+	type lineCov struct {
+		count           int
+		branch          bool
+		taken, notTaken int
+	}
+	lines := map[int]*lineCov{}
+	for a := range c.code {
+		n := c.prog.lines[a]
+		if !c.code[a] || n == 0 {
+			continue
+		}
+		l, ok := lines[n]
+		if !ok {
+			l = &lineCov{}
+			lines[n] = l
+		}
+		l.count += c.count[a]
+		l.branch = l.branch || c.branch[a]
+		l.taken += c.taken[a]
+		l.notTaken += c.notTaken[a]
+	}
+
+	nums := make([]int, 0, len(lines))
+	for n := range lines {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+
+	fmt.Fprintf(w, "TN:\nSF:%s\n", source)
+	var hit, brf, brh int
+	for _, n := range nums {
+		l := lines[n]
+		if !l.branch {
+			continue
+		}
+		brf += 2
+		for b, v := range []int{l.taken, l.notTaken} {
+			t := "-"
+			if l.count > 0 {
+				t = fmt.Sprint(v)
+			}
+			if v > 0 {
+				brh++
+			}
+			fmt.Fprintf(w, "BRDA:%d,0,%d,%s\n", n, b, t)
+		}
+	}
+	fmt.Fprintf(w, "BRF:%d\nBRH:%d\n", brf, brh)
+	for _, n := range nums {
+		if lines[n].count > 0 {
+			hit++
+		}
+		fmt.Fprintf(w, "DA:%d,%d\n", n, lines[n].count)
+	}
+	_, err := fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", len(nums), hit)
+	return err
+}
@@ -0,0 +1,109 @@
+package machine
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// coverMax runs examples/max.hypo with inputs a and b while collecting
+// coverage.
+func coverMax(t *testing.T, a, b int) *Coverage {
+	t.Helper()
+	f, err := os.Open("../examples/max.hypo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	p, err := ParseProgram(f)
+	if err != nil {
+		t.Fatalf("ParseProgram() = %v; want nil", err)
+	}
+
+	in := []int{a, b}
+	h := New(WithInput(func() (int, error) { v := in[0]; in = in[1:]; return v, nil }), WithOutput(func(int) {}))
+	h.Load(p)
+	c := h.StartCoverage()
+	h.Run()
+	if h.StopCoverage() != c {
+		t.Fatalf("h.StopCoverage() didn't return the coverage being collected")
+	}
+	return c
+}
+
+func TestCoverage(t *testing.T) {
+	cases := []struct {
+		a, b                                   int
+		cells, cellsHit, branches, branchesHit int
+		taken, notTaken                        int
+	}{
+		{3, 17, 9, 7, 2, 1, 1, 0},
+		{17, 3, 9, 7, 2, 1, 0, 1},
+	}
+
+	for i, c := range cases {
+		cov := coverMax(t, c.a, c.b)
+		cells, cellsHit, branches, branchesHit := cov.Summary()
+		if cells != c.cells || cellsHit != c.cellsHit || branches != c.branches || branchesHit != c.branchesHit {
+			t.Errorf("%02d: Summary() = (%d, %d, %d, %d); want (%d, %d, %d, %d)", i, cells, cellsHit, branches, branchesHit, c.cells, c.cellsHit, c.branches, c.branchesHit)
+		}
+		if taken, notTaken := cov.Branches(4); taken != c.taken || notTaken != c.notTaken {
+			t.Errorf("%02d: Branches(4) = (%d, %d); want (%d, %d)", i, taken, notTaken, c.taken, c.notTaken)
+		}
+		if cov.Executed(0) != 1 || cov.Executed(30) != 0 {
+			t.Errorf("%02d: Executed(0, 30) = (%d, %d); want (1, 0)", i, cov.Executed(0), cov.Executed(30))
+		}
+	}
+}
+
+func TestCoverageReport(t *testing.T) {
+	cov := coverMax(t, 3, 17)
+
+	var b bytes.Buffer
+	if err := cov.Report(&b); err != nil {
+		t.Fatalf("Report() = %v; want nil", err)
+	}
+	for _, want := range []string{
+		"Cells executed: 7/9 (77.8%)",
+		"Branches followed: 1/2 (50.0%)",
+		"     7  04         1  JLE 007          1          0  If AC <= 0",
+		"!    8  05         0  PUT 030",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("Report() = %q; want it to contain %q", b.String(), want)
+		}
+	}
+}
+
+func TestWriteLcov(t *testing.T) {
+	cov := coverMax(t, 3, 17)
+
+	var b bytes.Buffer
+	if err := cov.WriteLcov(&b, "examples/max.hypo"); err != nil {
+		t.Fatalf("WriteLcov() = %v; want nil", err)
+	}
+	want := `TN:
+SF:examples/max.hypo
+BRDA:7,0,0,1
+BRDA:7,0,1,0
+BRF:2
+BRH:1
+DA:3,1
+DA:4,1
+DA:5,1
+DA:6,1
+DA:7,1
+DA:8,0
+DA:9,0
+DA:10,1
+DA:11,1
+LF:9
+LH:7
+end_of_record
+`
+	if b.String() != want {
+		t.Errorf("WriteLcov() = %q; want %q", b.String(), want)
+	}
+}
@@ -0,0 +1,206 @@
+// This file contains the debugger support for hypo: breakpoints on
+// program counter addresses and watchpoints on memory cells.
+
+package machine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors returned by ParseWatchKind and the breakpoint and
+// watchpoint methods.
+var (
+	ErrDebugBadAddr = errors.New("Invalid memory address - can't watch or break there")
+	ErrDebugBadKind = errors.New("Invalid watchpoint kind")
+	ErrDebugNoPoint = errors.New("No such breakpoint or watchpoint")
+)
+
+// WatchKind selects the memory accesses that trigger a watchpoint. The
+// kinds may be combined.
+type WatchKind int
+
+const (
+	WatchRead   WatchKind = 1 << iota // Any instruction reads the cell
+	WatchWrite                        // Any instruction writes the cell
+	WatchChange                       // An instruction writes a different value to the cell
+)
+
+func (k WatchKind) String() string {
+	s := ""
+	if k&WatchRead != 0 {
+		s += "r"
+	}
+	if k&WatchWrite != 0 {
+		s += "w"
+	}
+	if k&WatchChange != 0 {
+		s += "c"
+	}
+	return s
+}
+
+// ParseWatchKind converts a combination of the letters r (read), w
+// (write) and c (change) to a WatchKind, or returns ErrDebugBadKind.
+func ParseWatchKind(s string) (WatchKind, error) {
+	var k WatchKind
+	for _, c := range strings.ToLower(s) {
+		switch c {
+		case 'r':
+			k |= WatchRead
+		case 'w':
+			k |= WatchWrite
+		case 'c':
+			k |= WatchChange
+		default:
+			return 0, ErrDebugBadKind
+		}
+	}
+	if k == 0 {
+		return 0, ErrDebugBadKind
+	}
+	return k, nil
+}
+
+// breakpoint is either a breakpoint on a PC address (watch is 0) or a
+// watchpoint on a memory cell. Both share a single set of ids.
+type breakpoint struct {
+	id      int
+	addr    int
+	watch   WatchKind
+	enabled bool
+}
+
+func (b *breakpoint) String() string {
+	s := fmt.Sprintf("breakpoint %d at %02d", b.id, b.addr)
+	if b.watch != 0 {
+		s = fmt.Sprintf("watchpoint %d on %02d [%s]", b.id, b.addr, b.watch)
+	}
+	if !b.enabled {
+		s += " (disabled)"
+	}
+	return s
+}
+
+// memAccess reports whether op reads and/or writes the memory cell at
+// its target address. Instructions that use the address as a jump
+// target do neither.
+func memAccess(op string) (read, write bool) {
+	switch op {
+	case "LAC", "LMQ", "LEP", "ADD", "SUB", "MUL", "DIV", "PUT", "CHN", "PCH":
+		return true, false
+	case "PAC", "PMQ", "PEP", "GET", "GCH":
+		return false, true
+	}
+	return false, false
+}
+
+// SetBreakpoint arranges for Run to pause before executing the
+// instruction at addr. It returns the id of the new breakpoint, or
+// ErrDebugBadAddr if addr is outside memory.
+func (h *Machine) SetBreakpoint(addr int) (int, error) {
+	return h.addBreakpoint(addr, 0)
+}
+
+// SetWatchpoint arranges for execution to pause after an instruction
+// accesses the memory cell at addr in a way matching kind. It returns
+// the id of the new watchpoint, or ErrDebugBadAddr or
+// ErrDebugBadKind.
+func (h *Machine) SetWatchpoint(addr int, kind WatchKind) (int, error) {
+	if kind == 0 || kind&^(WatchRead|WatchWrite|WatchChange) != 0 {
+		return 0, ErrDebugBadKind
+	}
+	return h.addBreakpoint(addr, kind)
+}
+
+func (h *Machine) addBreakpoint(addr int, kind WatchKind) (int, error) {
+	if !inBounds(addr) {
+		return 0, ErrDebugBadAddr
+	}
+	h.nextBreak++
+	h.breaks = append(h.breaks, &breakpoint{h.nextBreak, addr, kind, true})
+	return h.nextBreak, nil
+}
+
+// findBreakpoint returns the breakpoint or watchpoint with the given
+// id and its index in h.breaks.
+func (h *Machine) findBreakpoint(id int) (int, *breakpoint, error) {
+	for i, b := range h.breaks {
+		if b.id == id {
+			return i, b, nil
+		}
+	}
+	return 0, nil, ErrDebugNoPoint
+}
+
+// EnableBreakpoint enables the breakpoint or watchpoint with the given
+// id. It returns ErrDebugNoPoint if there is none.
+func (h *Machine) EnableBreakpoint(id int) error {
+	_, b, err := h.findBreakpoint(id)
+	if err != nil {
+		return err
+	}
+	b.enabled = true
+	return nil
+}
+
+// DisableBreakpoint disables the breakpoint or watchpoint with the
+// given id without deleting it. It returns ErrDebugNoPoint if there
+// is none.
+func (h *Machine) DisableBreakpoint(id int) error {
+	_, b, err := h.findBreakpoint(id)
+	if err != nil {
+		return err
+	}
+	b.enabled = false
+	return nil
+}
+
+// DeleteBreakpoint removes the breakpoint or watchpoint with the given
+// id. It returns ErrDebugNoPoint if there is none.
+func (h *Machine) DeleteBreakpoint(id int) error {
+	i, _, err := h.findBreakpoint(id)
+	if err != nil {
+		return err
+	}
+	h.breaks = append(h.breaks[:i], h.breaks[i+1:]...)
+	return nil
+}
+
+// DumpBreakpoints prints all breakpoints and watchpoints to the console.
+func (h *Machine) DumpBreakpoints() {
+	if len(h.breaks) == 0 {
+		fmt.Fprintln(h.console, "No breakpoints or watchpoints.")
+	}
+	for _, b := range h.breaks {
+		fmt.Fprintln(h.console, b)
+	}
+}
+
+// atBreakpoint returns the first enabled breakpoint on addr, or nil.
+func (h *Machine) atBreakpoint(addr int) *breakpoint {
+	for _, b := range h.breaks {
+		if b.enabled && b.watch == 0 && b.addr == addr {
+			return b
+		}
+	}
+	return nil
+}
+
+// checkWatch returns the first enabled watchpoint triggered by an
+// instruction accessing addr, or nil. old is the value of the cell
+// before the instruction ran.
+func (h *Machine) checkWatch(addr int, read, write bool, old int) *breakpoint {
+	for _, b := range h.breaks {
+		if !b.enabled || b.watch == 0 || b.addr != addr {
+			continue
+		}
+		if (read && b.watch&WatchRead != 0) ||
+			(write && b.watch&WatchWrite != 0) ||
+			(write && b.watch&WatchChange != 0 && (old != h.mem[addr] || h.devs[addr] != nil)) {
+			return b
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package machine
+
+import (
+	"strings"
+	"testing"
+)
+
+// loop is a program that prints 1, 2, 3 and halts. Address 20 is the
+// counter; address 21 holds the increment and 22 the limit.
+const loop = `0: 10020
+1: 20021
+2: 11020
+3: 31020
+4: 21022
+5: 03000
+6: 00000
+20: 0
+21: 1
+22: 3`
+
+func newLoopMachine(t *testing.T) (*Machine, *[]int) {
+	t.Helper()
+	var out []int
+	h := New(WithHistory())
+	h.chans[0].out = func(i int) { out = append(out, i) }
+	if err := h.LoadProgram(strings.NewReader(loop)); err != nil {
+		t.Fatalf("h.LoadProgram(loop) = %v; want nil", err)
+	}
+	return h, &out
+}
+
+func TestParseWatchKind(t *testing.T) {
+	cases := []struct {
+		in   string
+		want WatchKind
+		ok   bool
+	}{
+		{"r", WatchRead, true},
+		{"w", WatchWrite, true},
+		{"c", WatchChange, true},
+		{"RW", WatchRead | WatchWrite, true},
+		{"rwc", WatchRead | WatchWrite | WatchChange, true},
+		{"", 0, false},
+		{"x", 0, false},
+	}
+
+	for i, c := range cases {
+		got, err := ParseWatchKind(c.in)
+		if got != c.want || (err == nil) != c.ok {
+			t.Errorf("%02d: ParseWatchKind(%q) = (%v, %v); want (%v, ok = %t)", i, c.in, got, err, c.want, c.ok)
+		}
+	}
+}
+
+func TestBreakpoint(t *testing.T) {
+	h, out := newLoopMachine(t)
+	if _, err := h.SetBreakpoint(3); err != nil {
+		t.Fatalf("h.SetBreakpoint(3) = %v; want nil", err)
+	}
+
+	// Each Run should stop before the PUT, then continue past it.
+	for n := 0; n < 3; n++ {
+		h.Run()
+		if !h.Paused() || h.Halted() || h.pc != 3 || len(*out) != n {
+			t.Fatalf("%02d: after Run state = %s, pc = %d, output = %v; want paused at 3 with %d outputs", n, h.state, h.pc, *out, n)
+		}
+	}
+
+	h.Run()
+	if h.state != CPUhalt || len(*out) != 3 {
+		t.Errorf("after final Run state = %s, output = %v; want CPUhalt with 3 outputs", h.state, *out)
+	}
+}
+
+func TestWatchpoint(t *testing.T) {
+	cases := []struct {
+		addr   int
+		kind   WatchKind
+		wantPC int // PC after the first pause, -1 if it shouldn't pause
+	}{
+		{20, WatchRead, 1},                // LAC 20
+		{20, WatchWrite, 3},               // PAC 20
+		{20, WatchChange, 3},              // PAC 20 stores a new value
+		{21, WatchRead | WatchWrite, 2},   // ADD 21
+		{21, WatchWrite, -1},              // Never written
+		{22, WatchChange, -1},             // Never written
+		{30, WatchRead | WatchChange, -1}, // Never touched
+	}
+
+	for i, c := range cases {
+		h, _ := newLoopMachine(t)
+		if _, err := h.SetWatchpoint(c.addr, c.kind); err != nil {
+			t.Fatalf("%02d: h.SetWatchpoint(%d, %s) = %v; want nil", i, c.addr, c.kind, err)
+		}
+		h.Run()
+		switch {
+		case c.wantPC < 0 && h.state != CPUhalt:
+			t.Errorf("%02d: watch %d [%s]: state = %s; want CPUhalt", i, c.addr, c.kind, h.state)
+		case c.wantPC >= 0 && (!h.Paused() || h.pc != c.wantPC):
+			t.Errorf("%02d: watch %d [%s]: state = %s, pc = %d; want CPUpaused at %d", i, c.addr, c.kind, h.state, h.pc, c.wantPC)
+		}
+	}
+}
+
+func TestWatchChangeSameValue(t *testing.T) {
+	h := New()
+	h.mem[0] = 11010 // PAC 10, with AC = 0 and mem[10] = 0
+	h.mem[1] = 11010
+	h.ac = 0
+	if _, err := h.SetWatchpoint(10, WatchChange); err != nil {
+		t.Fatalf("h.SetWatchpoint() = %v; want nil", err)
+	}
+
+	h.Step()
+	if h.Paused() {
+		t.Errorf("Writing an unchanged value paused execution")
+	}
+
+	h.ac = 5
+	h.Step()
+	if !h.Paused() {
+		t.Errorf("Writing a changed value didn't pause execution")
+	}
+}
+
+func TestManageBreakpoints(t *testing.T) {
+	h, out := newLoopMachine(t)
+
+	if _, err := h.SetBreakpoint(MemSize); err != ErrDebugBadAddr {
+		t.Errorf("h.SetBreakpoint(MemSize) = %v; want %v", err, ErrDebugBadAddr)
+	}
+	if _, err := h.SetWatchpoint(0, 0); err != ErrDebugBadKind {
+		t.Errorf("h.SetWatchpoint(0, 0) = %v; want %v", err, ErrDebugBadKind)
+	}
+
+	b, _ := h.SetBreakpoint(3)
+	w, _ := h.SetWatchpoint(20, WatchWrite)
+	if b == w {
+		t.Fatalf("Breakpoint and watchpoint share id %d", b)
+	}
+
+	if err := h.DisableBreakpoint(b); err != nil {
+		t.Fatalf("h.DisableBreakpoint(%d) = %v; want nil", b, err)
+	}
+	h.Run()
+	if !h.Paused() || h.hit.id != w {
+		t.Errorf("Run stopped by %v; want watchpoint %d", h.hit, w)
+	}
+
+	if err := h.EnableBreakpoint(b); err != nil {
+		t.Fatalf("h.EnableBreakpoint(%d) = %v; want nil", b, err)
+	}
+	if err := h.DisableBreakpoint(w); err != nil {
+		t.Fatalf("h.DisableBreakpoint(%d) = %v; want nil", w, err)
+	}
+	h.Run()
+	if !h.Paused() || h.hit.id != b {
+		t.Errorf("Run stopped by %v; want breakpoint %d", h.hit, b)
+	}
+
+	for _, id := range []int{b, w} {
+		if err := h.DeleteBreakpoint(id); err != nil {
+			t.Errorf("h.DeleteBreakpoint(%d) = %v; want nil", id, err)
+		}
+		if err := h.DeleteBreakpoint(id); err != ErrDebugNoPoint {
+			t.Errorf("h.DeleteBreakpoint(%d) twice = %v; want %v", id, err, ErrDebugNoPoint)
+		}
+	}
+
+	h.Run()
+	if h.state != CPUhalt || len(*out) != 3 {
+		t.Errorf("state = %s, output = %v; want CPUhalt with 3 outputs", h.state, *out)
+	}
+}
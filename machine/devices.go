@@ -0,0 +1,62 @@
+// This file contains reference devices for the device bus.
+
+package machine
+
+import "math/rand"
+
+// Console is a device occupying one address. Reading it takes a value
+// from In and writing it passes the value to Out, just as GET and PUT
+// do, and failed reads follow the same input policy.
+type Console struct {
+	In  Getter
+	Out Putter
+}
+
+func (c *Console) Read(off int) (int, error) {
+	return c.In()
+}
+
+func (c *Console) Write(off, v int) {
+	c.Out(v)
+}
+
+// Random is a device occupying one address. Reading it returns a
+// random number from 0 to 99999 and writing it reseeds the generator
+// with the value written.
+type Random struct {
+	r *rand.Rand
+}
+
+// NewRandom returns a Random device seeded with seed.
+func NewRandom(seed int64) *Random {
+	return &Random{rand.New(rand.NewSource(seed))}
+}
+
+func (r *Random) Read(off int) (int, error) {
+	return r.r.Intn(100000), nil
+}
+
+func (r *Random) Write(off, v int) {
+	r.r.Seed(int64(v))
+}
+
+// Cycles is a device occupying one address that counts the
+// instructions executed. Reading it returns the count, which stops at
+// 99999, and writing it sets the count.
+type Cycles struct {
+	n int
+}
+
+func (c *Cycles) Read(off int) (int, error) {
+	return c.n, nil
+}
+
+func (c *Cycles) Write(off, v int) {
+	c.n = v
+}
+
+func (c *Cycles) Tick() {
+	if c.n < 99999 {
+		c.n++
+	}
+}
@@ -0,0 +1,79 @@
+package machine
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestConsoleDevice(t *testing.T) {
+	var out []int
+	h := New(WithConsole(&strings.Builder{}))
+	h.MapDevice(49, 1, &Console{In: func() (int, error) { return 6, nil }, Out: func(v int) { out = append(out, v) }})
+	h.mem[0], h.mem[1], h.mem[2] = 10049, 20049, 11049
+	h.Run()
+	if fmt.Sprint(out) != "[12]" {
+		t.Errorf("Console output = %v; want [12]", out)
+	}
+
+	// Reading the console follows the input policy.
+	cases := []struct {
+		policy InputPolicy
+		want   string // State, PC, AC and input error
+	}{
+		{InputFail, "CPUio 1 6 EOF"},
+		{InputDefault, "CPUhalt 3 5 <nil>"},
+	}
+	for i, c := range cases {
+		in := []int{6}
+		h := New(WithInputPolicy(c.policy, -1), WithConsole(&strings.Builder{}))
+		h.MapDevice(49, 1, &Console{In: func() (int, error) {
+			if len(in) == 0 {
+				return 0, io.EOF
+			}
+			v := in[0]
+			in = in[1:]
+			return v, nil
+		}})
+		h.mem[0], h.mem[1], h.mem[2] = 10049, 20049, 0
+		h.Run()
+		got := fmt.Sprintf("%s %d %d %v", h.state, h.pc, h.ac, h.InputError())
+		if got != c.want {
+			t.Errorf("%02d: reading a console with no input under %s = %s; want %s", i, c.policy, got, c.want)
+		}
+	}
+}
+
+func TestRandomDevice(t *testing.T) {
+	r := NewRandom(1)
+	var a []int
+	for i := 0; i < 3; i++ {
+		v, _ := r.Read(0)
+		a = append(a, v)
+	}
+	r.Write(0, 1)
+	for i, v := range a {
+		if got, _ := r.Read(0); got != v || v < 0 || v > 99999 {
+			t.Errorf("%02d: Read() after reseeding = %d; want %d", i, got, v)
+		}
+	}
+}
+
+func TestCyclesDevice(t *testing.T) {
+	c := &Cycles{}
+	h := New(WithConsole(&strings.Builder{}))
+	h.MapDevice(49, 1, c)
+	h.mem[0], h.mem[1], h.mem[2], h.mem[3] = 31000, 31000, 10049, 0
+	h.Run()
+	if n, _ := c.Read(0); h.ac != 2 || n != 4 {
+		t.Errorf("Cycle count read by the third instruction = %d, after HLT = %d; want 2, 4", h.ac, n)
+	}
+
+	c.Write(0, 99998)
+	c.Tick()
+	c.Tick()
+	if n, _ := c.Read(0); n != 99999 {
+		t.Errorf("Cycle count = %d; want it to stop at 99999", n)
+	}
+}
@@ -0,0 +1,120 @@
+// This file contains the hypo disassembler. It renders memory back to
+// mnemonics, annotated with a guess at which cells are code.
+
+package machine
+
+import (
+	"fmt"
+	"io"
+)
+
+// successors returns the addresses that execution may continue at
+// after running instruction i, found at addr. Faulting instructions
+// have no successors.
+func successors(addr int, i Instruction, cs CPUState) []int {
+	if cs != CPUok {
+		return nil
+	}
+
+	var next []int
+	switch i.op {
+	case "HLT", "RET", "RTI":
+		// A RET continues after the CAL that reached it, which is
+		// already a successor of that CAL, and likewise an RTI after
+		// the instruction that trapped.
+		return nil
+	case "JMP":
+		return []int{i.addr}
+	case "CAL", "VEC":
+		// A trap handler may run whenever its VEC has run.
+		next = append(next, i.addr)
+	case "JEQ", "JGT", "JLT", "JLE", "JNE":
+		next = append(next, i.addr)
+	}
+
+	if inBounds(addr + 1) {
+		next = append(next, addr+1)
+	}
+	return next
+}
+
+// reachable returns the cells that can be reached as instructions by
+// following control flow from entry. Programs that modify their own
+// instructions may reach other cells at run time, so this is only a
+// guess at which cells are code.
+func reachable(mem *[MemSize]int, entry int) [MemSize]bool {
+	return ISAStandard.reachable(mem, entry)
+}
+
+// disassemble writes one line per used cell of p to w, decoding
+// instructions with isa. Each line shows the address, the raw value,
+// the decoded instruction, whether the cell looks like code or data
+// and any source comment.
+func disassemble(w io.Writer, p *Program, isa ISA) error {
+	code := isa.reachable(&p.mem, 0)
+	for a, v := range p.mem {
+		if !p.used[a] {
+			continue
+		}
+
+		i, m, cs := isa.decode(v)
+		inst := "???"
+		if cs == CPUok {
+			inst = instString(i, m)
+		}
+
+		kind := "data"
+		if code[a] {
+			kind = "code"
+		}
+
+		line := fmt.Sprintf("%02d: % 06d  %-7s  %s", a, v, inst, kind)
+		if p.comments[a] != "" {
+			line += "  // " + p.comments[a]
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Disassemble writes an annotated disassembly of the program to w.
+// Only addresses set by the program source are shown.
+func (p *Program) Disassemble(w io.Writer) error {
+	return disassemble(w, p, ISAStandard)
+}
+
+// DisassembleISA is like Disassemble, but decodes instructions with
+// isa.
+func (p *Program) DisassembleISA(w io.Writer, isa ISA) error {
+	return disassemble(w, p, isa)
+}
+
+// program returns a Program view of the machine's current memory with
+// every cell in use. Comments from the loaded program are kept for
+// cells that still hold the value it loaded.
+func (h *Machine) program() *Program {
+	p := &Program{mem: h.mem}
+	for a := range p.used {
+		p.used[a] = true
+		if h.prog != nil && h.prog.mem[a] == h.mem[a] {
+			p.comments[a] = h.prog.comments[a]
+			p.lines[a] = h.prog.lines[a]
+		}
+	}
+	if h.prog != nil {
+		p.labels = h.prog.labels
+		for _, o := range h.prog.overwritten {
+			if h.prog.mem[o.addr] == h.mem[o.addr] {
+				p.overwritten = append(p.overwritten, o)
+			}
+		}
+	}
+	return p
+}
+
+// Disassemble writes an annotated disassembly of all of memory to w.
+func (h *Machine) Disassemble(w io.Writer) error {
+	return disassemble(w, h.program(), h.isa)
+}
@@ -0,0 +1,92 @@
+package machine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReachable(t *testing.T) {
+	cases := []struct {
+		prog string
+		want []int // Addresses expected to be reachable
+	}{
+		{"", []int{0}},
+		{"0: 31002\n1: 05000\n2: 99103", []int{0, 1}},
+		{"0: 01003\n1: 31000\n3: 05001", []int{0, 1, 2, 3}},
+		{"0: 05049\n49: 10000", []int{0, 49}}, // Running off the end of memory
+		{"0: 32000\n1: 00000", []int{0}},      // Bad instructions stop execution
+		{"0: 10050\n1: 00000", []int{0}},      // So do bad addresses
+	}
+
+	for i, c := range cases {
+		p, err := ParseProgram(strings.NewReader(c.prog))
+		if err != nil {
+			t.Fatalf("%02d: ParseProgram() = %v; want nil", i, err)
+		}
+
+		var want [MemSize]bool
+		for _, a := range c.want {
+			want[a] = true
+		}
+		if got := reachable(&p.mem, 0); got != want {
+			t.Errorf("%02d: reachable(%q) = %v; want %v", i, c.prog, got, want)
+		}
+	}
+}
+
+func TestProgramDisassemble(t *testing.T) {
+	prog := "0: 0 // Comment line\n0: 31002 // Print it\n1: 05000\n2: 99103 // The value\n3: -5\n4: 32000"
+	want := strings.Join([]string{
+		"00:  31002  PUT 002  code  // Print it",
+		"01:  05000  JMP 000  code",
+		"02:  99103  ???      data  // The value",
+		"03: -00005  ???      data",
+		"04:  32000  ???      data",
+		"",
+	}, "\n")
+
+	p, err := ParseProgram(strings.NewReader(prog))
+	if err != nil {
+		t.Fatalf("ParseProgram() = %v; want nil", err)
+	}
+
+	var b bytes.Buffer
+	if err := p.Disassemble(&b); err != nil {
+		t.Fatalf("p.Disassemble() = %v; want nil", err)
+	}
+	if got := b.String(); got != want {
+		t.Errorf("p.Disassemble() wrote:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMachineDisassemble(t *testing.T) {
+	h := New()
+	if err := h.LoadProgram(strings.NewReader("0: 11001 // Store AC\n1: 31002 // Print it")); err != nil {
+		t.Fatalf("h.LoadProgram() = %v; want nil", err)
+	}
+	h.ac = 10000
+	h.Step()
+
+	var b bytes.Buffer
+	if err := h.Disassemble(&b); err != nil {
+		t.Fatalf("h.Disassemble() = %v; want nil", err)
+	}
+
+	lines := strings.Split(b.String(), "\n")
+	if len(lines) != MemSize+1 {
+		t.Fatalf("h.Disassemble() wrote %d lines; want %d", len(lines), MemSize+1)
+	}
+
+	// Address 1 was overwritten, so its source comment no longer applies.
+	for i, want := range []string{
+		"00:  11001  PAC 001  code  // Store AC",
+		"01:  10000  LAC 000  code",
+		"02:  00000  HLT 000  code",
+		"03:  00000  HLT 000  data",
+	} {
+		if lines[i] != want {
+			t.Errorf("line %d = %q; want %q", i, lines[i], want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// This file contains the instruction dispatch used by Step: a table of
+// handlers indexed by numeric opcode, and a cache of decoded cells.
+
+package machine
+
+// handler executes an instruction. Jumps and stores use addr, the
+// effective address, and other instructions use the operand value v.
+// PC has already been advanced past the instruction.
+type handler func(h *Machine, addr, v int)
+
+// handlers holds the handler for each opcode. Opcodes without one are
+// invalid. It must be kept in step with ops.
+var handlers = [100]handler{
+	0: func(h *Machine, addr, v int) { h.state = CPUhalt },
+	1: func(h *Machine, addr, v int) {
+		if h.ac == 0 {
+			h.pc = addr
+		}
+	},
+	2: func(h *Machine, addr, v int) {
+		if h.ac > 0 {
+			h.pc = addr
+		}
+	},
+	3: func(h *Machine, addr, v int) {
+		if h.ac < 0 {
+			h.pc = addr
+		}
+	},
+	4: func(h *Machine, addr, v int) {
+		if !h.traps.handling {
+			h.pc--
+			h.fault(CPUbadinst)
+			return
+		}
+		h.pc, h.traps.handling = h.traps.epc, false
+	},
+	5: func(h *Machine, addr, v int) { h.pc = addr },
+	6: func(h *Machine, addr, v int) {
+		if h.ac <= 0 {
+			h.pc = addr
+		}
+	},
+	7: func(h *Machine, addr, v int) {
+		if h.ac != 0 {
+			h.pc = addr
+		}
+	},
+	8: func(h *Machine, addr, v int) {
+		if len(h.stack) == StackSize {
+			h.fault(CPUstack)
+			return
+		}
+		h.stack = append(h.stack, h.pc)
+		h.pc = addr
+	},
+	9: func(h *Machine, addr, v int) {
+		if len(h.stack) == 0 {
+			h.fault(CPUstack)
+			return
+		}
+		h.pc = h.stack[len(h.stack)-1]
+		h.stack = h.stack[:len(h.stack)-1]
+	},
+	10: func(h *Machine, addr, v int) { h.ac = v },
+	11: func(h *Machine, addr, v int) { h.store(addr, h.ac) },
+	12: func(h *Machine, addr, v int) { h.mq = v },
+	13: func(h *Machine, addr, v int) { h.store(addr, h.mq) },
+	14: func(h *Machine, addr, v int) {
+		if h.ac < 0 || h.ac >= int(numTraps) {
+			h.pc--
+			h.fault(CPUbadinst)
+			return
+		}
+		h.SetTrap(Trap(h.ac), addr)
+	},
+	15: func(h *Machine, addr, v int) { h.traps.epc = v },
+	16: func(h *Machine, addr, v int) { h.store(addr, h.traps.epc) },
+	20: func(h *Machine, addr, v int) { h.ac = boundsCap(h.ac + v) },
+	21: func(h *Machine, addr, v int) { h.ac = boundsCap(h.ac - v) },
+	22: func(h *Machine, addr, v int) { h.mq = boundsCap(h.mq * v) },
+	23: func(h *Machine, addr, v int) {
+		if v == 0 {
+			h.fault(CPUdivzero)
+			return
+		}
+		h.ac = h.mq % v
+		h.mq = h.mq / v
+	},
+	30: func(h *Machine, addr, v int) {
+		g := h.chans[h.ch].in
+		if g == nil {
+			h.pc--
+			h.fault(CPUbadaddr)
+			return
+		}
+		if v, ok := h.read(g); ok {
+			h.store(addr, v)
+		}
+	},
+	31: func(h *Machine, addr, v int) {
+		p := h.chans[h.ch].out
+		if p == nil {
+			h.pc--
+			h.fault(CPUbadaddr)
+			return
+		}
+		p(v)
+	},
+	33: func(h *Machine, addr, v int) {
+		if !validChannel(v) {
+			h.pc--
+			h.fault(CPUbadaddr)
+			return
+		}
+		h.ch = v
+	},
+	34: func(h *Machine, addr, v int) {
+		g := h.chans[h.ch].cin
+		if g == nil {
+			h.pc--
+			h.fault(CPUbadaddr)
+			return
+		}
+		if v, ok := h.read(g); ok {
+			h.store(addr, v)
+		}
+	},
+	35: func(h *Machine, addr, v int) {
+		p := h.chans[h.ch].cout
+		if p == nil {
+			h.pc--
+			h.fault(CPUbadaddr)
+			return
+		}
+		p(v)
+	},
+}
+
+// cell is the decoded form of a memory word, as cached by Step.
+type cell struct {
+	ok          bool // Whether the entry matches memory
+	inst        Instruction
+	mode        addrMode
+	cs          CPUState
+	exec        handler // Set if cs is CPUok
+	read, write bool    // How the instruction accesses memory at its effective address
+}
+
+// offEnd is the cell fetched when PC is outside memory.
+var offEnd = cell{ok: true, inst: Instruction{"UNK", 0}, cs: CPUbadinst}
+
+// decodeCell decodes the memory word d with isa.
+func decodeCell(d int, isa ISA) cell {
+	i, m, cs := isa.decode(d)
+	c := cell{ok: true, inst: i, mode: m, cs: cs}
+	if cs == CPUok {
+		c.exec = handlers[d/1000]
+		if m != modeImmediate {
+			c.read, c.write = memAccess(i.op)
+		}
+	}
+	return c
+}
+
+// fetch returns the decoded cell at addr, decoding it if the cache
+// entry isn't current. The entry is only valid until the next store to
+// addr. Instructions are always fetched from memory, even where a
+// device is mapped, as reading a device may have side effects.
+func (h *Machine) fetch(addr int) *cell {
+	if !inBounds(addr) {
+		return &offEnd
+	}
+	c := &h.cells[addr]
+	if !c.ok {
+		*c = decodeCell(h.mem[addr], h.isa)
+	}
+	return c
+}
+
+// store writes v to addr, or to the device mapped there, invalidating
+// the cached decoding.
+func (h *Machine) store(addr, v int) {
+	if m := h.devs[addr]; m != nil {
+		m.dev.Write(addr-m.base, v)
+		return
+	}
+	h.mem[addr] = v
+	h.cells[addr].ok = false
+}
+
+// flushCells invalidates every cached decoding, after memory has been
+// replaced wholesale.
+func (h *Machine) flushCells() {
+	h.cells = [MemSize]cell{}
+}
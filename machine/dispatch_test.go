@@ -0,0 +1,274 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// legacyStep is Step as it was before dispatch went through the
+// handler table and decoded cell cache: every instruction is decoded
+// afresh and dispatched on its mnemonic. It's frozen as a reference
+// for the tests and benchmarks below, so it only knows the opcodes
+// Step had then and reads input on channel 0 as Step did then.
+func legacyStep(h *Machine) {
+	i, cs := h.getInstruction(h.pc)
+	d := delta{pc: h.pc, ac: h.ac, mq: h.mq, state: h.state, addr: -1}
+	if _, write := memAccess(i.op); write && cs == CPUok {
+		d.addr, d.old = i.addr, h.mem[i.addr]
+	}
+	h.hist.push(d)
+
+	if h.state == CPUpaused {
+		h.state = CPUok
+	}
+	h.hit = nil
+
+	if cs != CPUok {
+		h.state = cs
+		return
+	}
+
+	read, write := memAccess(i.op)
+	var old int
+	if read || write {
+		old = h.mem[i.addr]
+	}
+
+	h.pc += 1
+	switch i.op {
+	case "HLT":
+		h.state = CPUhalt
+	case "JEQ", "JGT", "JLT", "JMP", "JLE", "JNE":
+		if jumpTaken(i.op, h.ac) {
+			h.pc = i.addr
+		}
+	case "LAC":
+		h.ac = h.mem[i.addr]
+	case "PAC":
+		h.mem[i.addr] = h.ac
+	case "LMQ":
+		h.mq = h.mem[i.addr]
+	case "PMQ":
+		h.mem[i.addr] = h.mq
+	case "ADD":
+		h.ac = boundsCap(h.ac + h.mem[i.addr])
+	case "SUB":
+		h.ac = boundsCap(h.ac - h.mem[i.addr])
+	case "MUL":
+		h.mq = boundsCap(h.mq * h.mem[i.addr])
+	case "DIV":
+		if h.mem[i.addr] == 0 {
+			h.state = CPUdivzero
+			return
+		}
+		h.ac = h.mq % h.mem[i.addr]
+		h.mq = h.mq / h.mem[i.addr]
+	case "GET":
+		v, _ := h.chans[0].in()
+		h.mem[i.addr] = boundsCap(v)
+	case "PUT":
+		h.chans[0].out(h.mem[i.addr])
+	default:
+		h.state = CPUbadinst
+	}
+
+	if read || write {
+		if b := h.checkWatch(i.addr, read, write, old); b != nil && h.state == CPUok {
+			h.hit = b
+			h.state = CPUpaused
+		}
+	}
+}
+
+// legacyOps are the mnemonics legacyStep knows.
+var legacyOps = map[string]bool{
+	"HLT": true, "JEQ": true, "JGT": true, "JLT": true, "JMP": true, "JLE": true, "JNE": true,
+	"LAC": true, "PAC": true, "LMQ": true, "PMQ": true,
+	"ADD": true, "SUB": true, "MUL": true, "DIV": true, "GET": true, "PUT": true,
+}
+
+func TestHandlers(t *testing.T) {
+	for op := range handlers {
+		if _, ok := ops[op]; ok != (handlers[op] != nil) {
+			t.Errorf("Opcode %02d: in ops = %t, has handler = %t", op, ok, handlers[op] != nil)
+		}
+	}
+}
+
+// randomProgram fills memory with a mix of valid instructions, small
+// numbers and random words.
+// Instructions only use the opcodes legacyStep knows.
+func randomProgram(r *rand.Rand) *Program {
+	var codes []int
+	for op, m := range ops {
+		if legacyOps[m] {
+			codes = append(codes, op)
+		}
+	}
+	p := &Program{}
+	for a := range p.mem {
+		switch r.Intn(4) {
+		case 0:
+			p.mem[a] = r.Intn(199999) - 99999
+		case 1:
+			p.mem[a] = r.Intn(20) - 10
+		default:
+			p.mem[a] = codes[r.Intn(len(codes))]*1000 + r.Intn(MemSize)
+		}
+	}
+	return p
+}
+
+// TestStepMatchesLegacy runs random programs, many of which modify
+// their own instructions, with both Step and legacyStep and checks
+// that the machines stay in the same state. A program is only followed
+// until it reaches an opcode legacyStep doesn't know; TestStepOpcodes
+// covers those.
+func TestStepMatchesLegacy(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 500; n++ {
+		p := randomProgram(r)
+		var outs [2][]int
+		var hs [2]*Machine
+		for k := range hs {
+			k := k
+			in := 0
+			hs[k] = New(WithInput(func() (int, error) { in += 7919; return in%199999 - 99999, nil }), WithOutput(func(i int) { outs[k] = append(outs[k], i) }), WithConsole(&strings.Builder{}))
+			hs[k].Load(p)
+		}
+
+		for s := 0; s < 1000 && !hs[0].Halted(); s++ {
+			if i, cs := hs[0].getInstruction(hs[0].pc); cs == CPUok && !legacyOps[i.op] {
+				break
+			}
+			hs[0].Step()
+			legacyStep(hs[1])
+			if hs[0].mem != hs[1].mem || hs[0].pc != hs[1].pc || hs[0].ac != hs[1].ac || hs[0].mq != hs[1].mq || hs[0].state != hs[1].state {
+				t.Fatalf("Program %d diverged after %d steps:\n%v\n%v", n, s+1, p.mem, hs[0].mem)
+			}
+		}
+		if len(outs[0]) != len(outs[1]) {
+			t.Errorf("Program %d: output %v; want %v", n, outs[0], outs[1])
+		}
+	}
+}
+
+// TestStepOpcodes runs the opcodes added since legacyStep was frozen.
+func TestStepOpcodes(t *testing.T) {
+	cases := []struct {
+		prog string
+		want string // Output, state, PC, AC, return stack, EPC and channel
+	}{
+		// CAL and RET.
+		{"0: 08010\n1: 31012\n2: 0\n10: 09000\n12: 5", "[0:5] CPUhalt 3 0 [] 0 0"},
+		{"0: 08000", "[] CPUstack 1 0 [1 1 1 1 1 1 1 1] 0 0"},
+		{"0: 09000", "[] CPUstack 1 0 [] 0 0"},
+		// VEC installs a handler for the DIV by zero, which saves the
+		// EPC with PEP, sets it with LEP and returns to it with RTI.
+		{"0: 10010\n1: 14020\n2: 23011\n3: 31030\n4: 0\n10: 2\n20: 16030\n21: 15031\n22: 04000\n31: 4", "[] CPUhalt 5 2 [] 4 0"},
+		{"0: 10010\n1: 14020\n2: 23011\n3: 31030\n4: 0\n10: 2\n20: 16030\n21: 15031\n22: 04000\n31: 3", "[0:3] CPUhalt 5 2 [] 3 0"},
+		{"0: 10010\n1: 14020\n2: 0\n10: 9", "[] CPUbadinst 1 9 [] 0 0"},
+		{"0: 04000", "[] CPUbadinst 0 0 [] 0 0"},
+		// CHN selects the channel for GET, PUT, GCH and PCH.
+		{"0: 33010\n1: 31011\n2: 33012\n3: 31011\n4: 0\n10: 2\n11: 6", "[2:6 0:6] CPUhalt 5 0 [] 0 0"},
+		{"0: 33010\n1: 30011\n2: 0\n10: 2", "[] CPUbadaddr 1 0 [] 0 2"},
+		{"0: 33010\n10: 99", "[] CPUbadaddr 0 0 [] 0 0"},
+		{"0: 34010\n1: 35010\n2: 0", "[c:65] CPUhalt 3 0 [] 0 0"},
+	}
+
+	for i, c := range cases {
+		p, err := ParseProgram(strings.NewReader(c.prog))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := []string{}
+		put := func(f string) Putter {
+			return func(v int) { out = append(out, fmt.Sprintf(f, v)) }
+		}
+		h := New(WithOutput(put("0:%d")), WithCharInput(func() (int, error) { return 65, nil }), WithCharOutput(put("c:%d")),
+			WithChannel(2, nil, put("2:%d")), WithConsole(&strings.Builder{}))
+		h.Load(p)
+		h.RunContext(context.Background(), 100)
+
+		got := fmt.Sprintf("%v %s %d %d %v %d %d", out, h.state, h.pc, h.ac, h.stack, h.traps.epc, h.ch)
+		if got != c.want {
+			t.Errorf("%02d: running %q = %s; want %s", i, c.prog, got, c.want)
+		}
+	}
+}
+
+// TestStepCacheInvalidation checks that stores by each writing
+// instruction, and undoing them, are seen by later steps. Each program
+// runs the LAC at 0, replaces it with PUT 12 and sets a flag at 13,
+// then jumps back to 0 to run the PUT and halt.
+func TestStepCacheInvalidation(t *testing.T) {
+	common := "0: 10012\n1: 10013\n2: 07008\n6: 05000\n8: 0\n12: 42\n14: 31012\n"
+	cases := []string{
+		"3: 10014\n4: 11000\n5: 11013", // PAC
+		"3: 12014\n4: 13000\n5: 13013", // PMQ
+		"3: 10014\n4: 30000\n5: 11013", // GET
+		"3: 10014\n4: 34000\n5: 11013", // GCH
+		"3: 15014\n4: 16000\n5: 16013", // PEP
+	}
+
+	for i, c := range cases {
+		p, err := ParseProgram(strings.NewReader(common + c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []int
+		get := func() (int, error) { return 31012, nil }
+		h := New(WithHistory(), WithInput(get), WithCharInput(get), WithOutput(func(v int) { out = append(out, v) }), WithConsole(&strings.Builder{}))
+		h.Load(p)
+
+		h.Run()
+		for h.StepBack() {
+		}
+		h.Run()
+		if h.state != CPUhalt || len(out) != 2 || out[0] != 42 || out[1] != 42 {
+			t.Errorf("%02d: Run(), StepBack() to the start and Run() = %s with output %v; want CPUhalt with [42 42]", i, h.state, out)
+		}
+	}
+}
+
+// benchProgram loops forever over a mix of instructions.
+const benchProgram = `0: 10020 // LAC 20
+1: 20021 // ADD 21
+2: 11020 // PAC 20
+3: 12020 // LMQ 20
+4: 23022 // DIV 22
+5: 13023 // PMQ 23
+6: 22021 // MUL 21
+7: 21023 // SUB 23
+8: 06000 // JLE 0
+9: 05000 // JMP 0
+21: 1
+22: 7`
+
+func benchStep(b *testing.B, step func(*Machine), opts ...Option) {
+	p, err := ParseProgram(strings.NewReader(benchProgram))
+	if err != nil {
+		b.Fatal(err)
+	}
+	h := New(append(opts, WithConsole(&strings.Builder{}))...)
+	h.Load(p)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		step(h)
+	}
+}
+
+func BenchmarkStep(b *testing.B) {
+	benchStep(b, (*Machine).Step)
+}
+
+func BenchmarkStepHistory(b *testing.B) {
+	benchStep(b, (*Machine).Step, WithHistory())
+}
+
+func BenchmarkStepLegacy(b *testing.B) {
+	benchStep(b, legacyStep)
+}
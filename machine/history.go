@@ -0,0 +1,120 @@
+// This file contains the execution history used to step the machine
+// backwards.
+
+package machine
+
+import "fmt"
+
+// histSize bounds the number of steps that can be undone.
+const histSize = 1000
+
+// delta records the machine state that a single Step may change, as
+// it was before the step, so the step can be undone.
+type delta struct {
+	pc, ac, mq int
+	state      CPUState
+	addr       int // The memory address the step wrote, or -1 if none
+	old        int // The value at addr before the step
+	depth      int // The depth of the return stack before the step
+	top        int // The innermost return address before the step, if depth > 0
+	traps      trapState
+	ch         int // The selected I/O channel
+}
+
+// history is a bounded ring of deltas. Once full, recording a new
+// delta forgets the oldest one.
+type history struct {
+	deltas []delta
+	start  int // Index of the oldest delta
+	n      int // Number of deltas held
+}
+
+func (hs *history) push(d delta) {
+	switch {
+	case hs.n < len(hs.deltas):
+		hs.deltas[(hs.start+hs.n)%len(hs.deltas)] = d
+		hs.n++
+	case len(hs.deltas) < histSize:
+		// Nothing has been forgotten yet, so start is still 0.
+		hs.deltas = append(hs.deltas, d)
+		hs.n++
+	default:
+		hs.deltas[hs.start] = d
+		hs.start = (hs.start + 1) % histSize
+	}
+}
+
+func (hs *history) pop() (delta, bool) {
+	if hs.n == 0 {
+		return delta{}, false
+	}
+	hs.n--
+	return hs.deltas[(hs.start+hs.n)%len(hs.deltas)], true
+}
+
+func (hs *history) clear() {
+	*hs = history{}
+}
+
+// WithHistory makes the machine record each step, so that StepBack
+// and RunBack can undo it. Recording slows Step down, so without this
+// option there is no history to step back through.
+func WithHistory() Option {
+	return func(h *Machine) { h.recording = true }
+}
+
+// record saves the state that executing the instruction in c at PC,
+// with effective address addr and decoded with state cs, is about to
+// change.
+func (h *Machine) record(c *cell, addr int, cs CPUState) {
+	d := delta{pc: h.pc, ac: h.ac, mq: h.mq, state: h.state, addr: -1, depth: len(h.stack), traps: h.traps, ch: h.ch}
+	if d.depth > 0 {
+		d.top = h.stack[d.depth-1]
+	}
+	// Writes to devices can't be undone.
+	if c.write && cs == CPUok && h.devs[addr] == nil {
+		d.addr, d.old = addr, h.mem[addr]
+	}
+	h.hist.push(d)
+}
+
+// StepBack undoes the most recent Step, restoring registers, the
+// return stack, trap state, the I/O channel, CPU state and any memory
+// cell the step wrote. Writes to devices aren't undone. It returns
+// false if there is no recorded history to undo, as is always the case
+// for a machine created without WithHistory.
+func (h *Machine) StepBack() bool {
+	d, ok := h.hist.pop()
+	if !ok {
+		return false
+	}
+
+	h.pc, h.ac, h.mq, h.state = d.pc, d.ac, d.mq, d.state
+	h.traps, h.ch = d.traps, d.ch
+	if d.addr >= 0 {
+		h.store(d.addr, d.old)
+	}
+	// A step pushes or pops at most one return address.
+	if len(h.stack) > d.depth {
+		h.stack = h.stack[:d.depth]
+	} else if len(h.stack) < d.depth {
+		h.stack = append(h.stack, d.top)
+	}
+	h.hit = nil
+	return true
+}
+
+// RunBack steps backwards until PC reaches an enabled breakpoint or
+// the recorded history is exhausted. The CPU is left paused at the
+// breakpoint, so Run or Step will continue forwards from there.
+func (h *Machine) RunBack() {
+	for h.StepBack() {
+		if b := h.atBreakpoint(h.pc); b != nil {
+			h.hit = b
+			h.state = CPUpaused
+			fmt.Fprintf(h.console, "Program paused by %s\n", h.hit)
+			return
+		}
+	}
+	fmt.Fprintln(h.console, "Reached the start of recorded history.")
+}
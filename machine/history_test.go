@@ -0,0 +1,167 @@
+package machine
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// machineState is the part of a machine that StepBack must restore.
+type machineState struct {
+	mem        [MemSize]int
+	pc, ac, mq int
+	state      CPUState
+	stack      string
+}
+
+func stateOf(h *Machine) machineState {
+	return machineState{h.mem, h.pc, h.ac, h.mq, h.state, fmt.Sprint(h.stack)}
+}
+
+func TestStepBack(t *testing.T) {
+	// Exercise every instruction that writes memory or registers, then
+	// fault on a divide by zero.
+	prog := `0: 30020
+1: 10020
+2: 11021
+3: 12020
+4: 22020
+5: 13022
+6: 23023
+7: 23024
+20: 0
+23: 7`
+
+	h := New(WithHistory())
+	h.chans[0].in = func() (int, error) { return 12, nil }
+	if err := h.LoadProgram(strings.NewReader(prog)); err != nil {
+		t.Fatalf("h.LoadProgram() = %v; want nil", err)
+	}
+
+	var states []machineState
+	for !h.Halted() {
+		states = append(states, stateOf(h))
+		h.Step()
+	}
+	if h.state != CPUdivzero {
+		t.Fatalf("h.state = %s; want CPUdivzero", h.state)
+	}
+
+	for i := len(states) - 1; i >= 0; i-- {
+		if !h.StepBack() {
+			t.Fatalf("%02d: h.StepBack() = false; want true", i)
+		}
+		if got := stateOf(h); got != states[i] {
+			t.Errorf("%02d: after StepBack state = %+v; want %+v", i, got, states[i])
+		}
+	}
+
+	if h.StepBack() {
+		t.Errorf("h.StepBack() at start of history = true; want false")
+	}
+}
+
+func TestStepBackCalls(t *testing.T) {
+	// Call a routine that calls another, return from both and then
+	// underflow the return stack.
+	prog := `0: 08010
+1: 09000
+10: 08020
+11: 09000
+20: 09000`
+
+	h := New(WithHistory())
+	if err := h.LoadProgram(strings.NewReader(prog)); err != nil {
+		t.Fatalf("h.LoadProgram() = %v; want nil", err)
+	}
+
+	var states []machineState
+	for !h.Halted() {
+		states = append(states, stateOf(h))
+		h.Step()
+	}
+	if h.state != CPUstack {
+		t.Fatalf("h.state = %s; want CPUstack", h.state)
+	}
+
+	for i := len(states) - 1; i >= 0; i-- {
+		h.StepBack()
+		if got := stateOf(h); got != states[i] {
+			t.Errorf("%02d: after StepBack state = %+v; want %+v", i, got, states[i])
+		}
+	}
+}
+
+func TestStepBackBounded(t *testing.T) {
+	h := New(WithHistory())
+	h.mem[0] = 5000 // JMP 0, forever
+
+	for i := 0; i < histSize+10; i++ {
+		h.Step()
+	}
+
+	n := 0
+	for h.StepBack() {
+		n++
+	}
+	if n != histSize {
+		t.Errorf("Stepped back %d times; want %d", n, histSize)
+	}
+}
+
+func TestStepBackWithoutHistory(t *testing.T) {
+	h := New()
+	h.mem[0] = 5000 // JMP 0, forever
+	h.Step()
+	if h.StepBack() {
+		t.Errorf("h.StepBack() without WithHistory = true; want false")
+	}
+}
+
+func TestStepBackInterleaved(t *testing.T) {
+	h := New(WithHistory())
+	h.mem[0] = 11010 // PAC 10
+	h.mem[1] = 11011 // PAC 11
+	h.ac = 4
+
+	h.Step()
+	h.Step()
+	h.StepBack()
+	h.ac = 5
+	h.Step()
+
+	h.StepBack()
+	if h.pc != 1 || h.mem[11] != 0 || h.mem[10] != 4 {
+		t.Errorf("pc = %d, mem[10] = %d, mem[11] = %d; want 1, 4, 0", h.pc, h.mem[10], h.mem[11])
+	}
+	h.StepBack()
+	if h.pc != 0 || h.mem[10] != 0 {
+		t.Errorf("pc = %d, mem[10] = %d; want 0, 0", h.pc, h.mem[10])
+	}
+	if h.StepBack() {
+		t.Errorf("h.StepBack() at start of history = true; want false")
+	}
+}
+
+func TestRunBack(t *testing.T) {
+	h, out := newLoopMachine(t)
+	h.Run()
+	if h.state != CPUhalt || len(*out) != 3 {
+		t.Fatalf("state = %s, output = %v; want CPUhalt with 3 outputs", h.state, *out)
+	}
+
+	// The counter is 3 when the loop finishes; the last time PC was at
+	// the PUT it was also 3, and each earlier visit one less.
+	h.SetBreakpoint(3)
+	for want := 3; want > 0; want-- {
+		h.RunBack()
+		if !h.Paused() || h.pc != 3 || h.mem[20] != want {
+			t.Fatalf("state = %s, pc = %d, counter = %d; want CPUpaused at 3 with counter %d", h.state, h.pc, h.mem[20], want)
+		}
+	}
+
+	h.RunBack()
+	if h.pc != 0 || h.mem[20] != 0 || h.state != CPUok {
+		t.Errorf("state = %s, pc = %d, counter = %d; want CPUok at 0 with counter 0", h.state, h.pc, h.mem[20])
+	}
+}
@@ -0,0 +1,149 @@
+// This file contains numeric input: reading values from text, and the
+// policy GET and GCH follow when their Getter fails.
+
+package machine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode"
+)
+
+// ErrBadInput is returned, wrapped, by Getters that read something
+// other than a value, and used by GET and GCH when a Getter returns a
+// value outside the range memory can hold.
+var ErrBadInput = errors.New("Invalid input")
+
+// InputPolicy says what GET and GCH do when their Getter returns an
+// error.
+type InputPolicy int
+
+const (
+	InputFail    InputPolicy = iota // Stop with CPUio
+	InputRetry                      // Read again after ErrBadInput, and stop with CPUio on other errors
+	InputDefault                    // Use the default value in place of the input
+)
+
+func (p InputPolicy) String() string {
+	switch p {
+	case InputFail:
+		return "fail"
+	case InputRetry:
+		return "retry"
+	case InputDefault:
+		return "default"
+	}
+	return "unknown"
+}
+
+// ParseInputPolicy returns the InputPolicy named s, as written by
+// String.
+func ParseInputPolicy(s string) (InputPolicy, bool) {
+	for p := InputFail; p <= InputDefault; p++ {
+		if p.String() == s {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
+// WithInputPolicy makes GET and GCH follow p when their Getter fails,
+// using def as the value read under InputDefault. Without it, they
+// follow InputFail. As memory can only hold -99999 to 99999, def is
+// bounded to that range just as arithmetic results are.
+func WithInputPolicy(p InputPolicy, def int) Option {
+	return func(h *Machine) { h.inPolicy, h.inDefault = p, boundsCap(def) }
+}
+
+// InputError returns the error that stopped the machine with CPUio, or
+// nil if it isn't in that state.
+func (h *Machine) InputError() error {
+	if h.state != CPUio {
+		return nil
+	}
+	return h.inErr
+}
+
+// input takes a value from g, following the input policy. Retries
+// and defaults are reported on the console. It returns an error if the
+// policy can't supply a value, and the CPU should stop with CPUio.
+func (h *Machine) input(g Getter) (int, error) {
+	for {
+		v, err := g()
+		if err == nil && v != boundsCap(v) {
+			err = fmt.Errorf("%w %d: outside -99999 to 99999", ErrBadInput, v)
+		}
+		switch {
+		case err == nil:
+			return v, nil
+		case h.inPolicy == InputRetry && errors.Is(err, ErrBadInput):
+			fmt.Fprintf(h.console, "Error reading input: %v. Try again.\n", err)
+			continue
+		case h.inPolicy == InputDefault:
+			fmt.Fprintf(h.console, "Error reading input: %v. Using %d.\n", err, h.inDefault)
+			return h.inDefault, nil
+		}
+		return 0, err
+	}
+}
+
+// read takes a value for GET or GCH from g with input. It returns
+// false if the CPU has faulted with CPUio instead, leaving PC at the
+// instruction.
+func (h *Machine) read(g Getter) (int, bool) {
+	v, err := h.input(g)
+	if err != nil {
+		h.pc--
+		h.ioFault(err)
+		return 0, false
+	}
+	return v, true
+}
+
+// ioFault faults with CPUio because input failed with err. The CPU
+// stops unless the program has a TrapIO handler.
+func (h *Machine) ioFault(err error) {
+	h.inErr = err
+	h.fault(CPUio)
+}
+
+// readWord reads the next run of non-space characters from r, and the
+// space after it, so that characters read from r next start after the
+// word's line or separator.
+func readWord(r io.RuneReader) (string, error) {
+	var w []rune
+	for {
+		c, _, err := r.ReadRune()
+		switch {
+		case err == io.EOF && len(w) > 0:
+			return string(w), nil
+		case err != nil:
+			return "", err
+		case unicode.IsSpace(c):
+			if len(w) > 0 {
+				return string(w), nil
+			}
+		default:
+			w = append(w, c)
+		}
+	}
+}
+
+// NumberGetter returns a Getter that reads whitespace separated
+// numbers from r. It returns io.EOF once r is exhausted, and
+// ErrBadInput for anything other than a number.
+func NumberGetter(r io.RuneReader) Getter {
+	return func() (int, error) {
+		w, err := readWord(r)
+		if err != nil {
+			return 0, err
+		}
+		v, err := strconv.Atoi(w)
+		if err != nil {
+			return 0, fmt.Errorf("%w %q", ErrBadInput, w)
+		}
+		return v, nil
+	}
+}
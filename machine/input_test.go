@@ -0,0 +1,97 @@
+package machine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseInputPolicy(t *testing.T) {
+	for p := InputFail; p <= InputDefault; p++ {
+		if got, ok := ParseInputPolicy(p.String()); !ok || got != p {
+			t.Errorf("ParseInputPolicy(%q) = %s, %t; want %s, true", p, got, ok, p)
+		}
+	}
+	if _, ok := ParseInputPolicy("ignore"); ok {
+		t.Errorf("ParseInputPolicy(\"ignore\") = _, true; want false")
+	}
+	if cs, ok := ParseCPUState("CPUio"); !ok || cs != CPUio {
+		t.Errorf("ParseCPUState(\"CPUio\") = %s, %t; want CPUio, true", cs, ok)
+	}
+}
+
+func TestNumberGetter(t *testing.T) {
+	g := NumberGetter(strings.NewReader(" 12\n-3 x4 7"))
+	want := []struct {
+		v   int
+		err error
+	}{{12, nil}, {-3, nil}, {0, ErrBadInput}, {7, nil}, {0, io.EOF}, {0, io.EOF}}
+	for i, w := range want {
+		v, err := g()
+		if v != w.v || !errors.Is(err, w.err) {
+			t.Errorf("%02d: g() = %d, %v; want %d, %v", i, v, err, w.v, w.err)
+		}
+	}
+}
+
+func TestInputPolicyDefaultRange(t *testing.T) {
+	for i, def := range []int{500000, -500000} {
+		h := New(WithInput(func() (int, error) { return 0, io.EOF }), WithInputPolicy(InputDefault, def), WithConsole(&strings.Builder{}))
+		h.mem[0], h.mem[1] = 30010, 0
+		h.Run()
+		if want := boundsCap(def); h.state != CPUhalt || h.mem[10] != want {
+			t.Errorf("%02d: GET with default %d = %s, mem[10] = %d; want CPUhalt, %d", i, def, h.state, h.mem[10], want)
+		}
+	}
+}
+
+func TestInputPolicy(t *testing.T) {
+	// Read a number and a character, and write them.
+	prog := "0: 30010\n1: 34011\n2: 31010\n3: 31011\n4: 0"
+	cases := []struct {
+		policy  InputPolicy
+		in      string
+		chars   Getter
+		want    string // Output, state, PC and input error
+		console string // Less the line Run ends with
+	}{
+		{InputFail, "5", nil, "[5 65] CPUhalt 5 <nil>", ""},
+		{InputFail, "", nil, "[] CPUio 0 EOF", "Error reading input: EOF\n"},
+		{InputFail, "x", nil, "[] CPUio 0 Invalid input \"x\"", "Error reading input: Invalid input \"x\"\n"},
+		{InputFail, "-100000", nil, "[] CPUio 0 Invalid input -100000: outside -99999 to 99999", "Error reading input: Invalid input -100000: outside -99999 to 99999\n"},
+		{InputRetry, "x 100000 5", nil, "[5 65] CPUhalt 5 <nil>", "Error reading input: Invalid input \"x\". Try again.\nError reading input: Invalid input 100000: outside -99999 to 99999. Try again.\n"},
+		{InputRetry, "x", nil, "[] CPUio 0 EOF", "Error reading input: Invalid input \"x\". Try again.\nError reading input: EOF\n"},
+		{InputDefault, "", nil, "[-1 65] CPUhalt 5 <nil>", "Error reading input: EOF. Using -1.\n"},
+		// GCH follows the policy too.
+		{InputFail, "5", func() (int, error) { return 0, io.ErrClosedPipe }, "[] CPUio 1 io: read/write on closed pipe", "Error reading input: io: read/write on closed pipe\n"},
+		{InputDefault, "5", func() (int, error) { return 0, io.ErrClosedPipe }, "[5 -1] CPUhalt 5 <nil>", "Error reading input: io: read/write on closed pipe. Using -1.\n"},
+	}
+
+	for i, c := range cases {
+		p, err := ParseProgram(strings.NewReader(prog))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.chars == nil {
+			c.chars = func() (int, error) { return 65, nil }
+		}
+		out := []int{}
+		var console strings.Builder
+		h := New(WithInput(NumberGetter(strings.NewReader(c.in))), WithOutput(func(v int) { out = append(out, v) }),
+			WithCharInput(c.chars), WithInputPolicy(c.policy, -1), WithConsole(&console))
+		h.Load(p)
+		console.Reset()
+		h.Run()
+
+		got := fmt.Sprintf("%v %s %d %v", out, h.State(), h.PC(), h.InputError())
+		if got != c.want {
+			t.Errorf("%02d: running with %s and %q = %s; want %s", i, c.policy, c.in, got, c.want)
+		}
+		msgs := strings.TrimSuffix(console.String(), fmt.Sprintf("Program terminated with: %q\n", h.State()))
+		if msgs != c.console {
+			t.Errorf("%02d: running with %s and %q wrote %q; want %q", i, c.policy, c.in, msgs, c.console)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// This file contains the extended instruction set, which uses the
+// middle digit of an instruction word to select an addressing mode.
+
+package machine
+
+import "fmt"
+
+// ISA selects how instruction words are decoded.
+type ISA int
+
+const (
+	ISAStandard ISA = iota // OP0AA: the middle digit is part of the address, so must be 0
+	ISAExtended            // OPMAA: the middle digit selects an addressing mode
+)
+
+func (s ISA) String() string {
+	switch s {
+	case ISAStandard:
+		return "standard"
+	case ISAExtended:
+		return "extended"
+	}
+	return "unknown"
+}
+
+// ParseISA returns the ISA named s, as written by String.
+func ParseISA(s string) (ISA, bool) {
+	for isa := ISAStandard; isa <= ISAExtended; isa++ {
+		if isa.String() == s {
+			return isa, true
+		}
+	}
+	return ISAStandard, false
+}
+
+// addrMode says how an instruction's address yields its operand.
+type addrMode int
+
+const (
+	modeDirect    addrMode = iota // The cell at the address
+	modeImmediate                 // The address itself, as a value
+	modeIndirect                  // The cell whose address is held in the cell at the address
+)
+
+// modePrefix marks the operand of an instruction using each mode, in
+// disassembly and assembler source.
+var modePrefix = map[addrMode]string{modeImmediate: "#", modeIndirect: "@"}
+
+// modeAllowed reports whether op can use mode m. Only instructions
+// that read a value can take an immediate one, and indirection needs
+// an instruction that uses its address.
+func modeAllowed(op string, m addrMode) bool {
+	read, write := memAccess(op)
+	switch m {
+	case modeDirect:
+		return true
+	case modeImmediate:
+		return read
+	case modeIndirect:
+		return read || write || jumps(op)
+	}
+	return false
+}
+
+// decode interprets the memory word d as an instruction and its
+// addressing mode. Words that are valid in the standard ISA decode
+// the same way in both, except that any with a non-zero middle digit
+// aren't valid addresses in the standard ISA.
+func (s ISA) decode(d int) (Instruction, addrMode, CPUState) {
+	if s == ISAStandard {
+		i, cs := Decode(d)
+		return i, modeDirect, cs
+	}
+
+	op, m, a := d/1000, addrMode(d%1000/100), d%100
+	o, ok := ops[op]
+	switch {
+	case !ok:
+		return Instruction{"UNK", a}, m, CPUbadinst
+	case m < 0 || a < 0:
+		return Instruction{o, d % 1000}, modeDirect, CPUbadaddr
+	case m > modeIndirect || !modeAllowed(o, m):
+		return Instruction{o, a}, m, CPUbadinst
+	case !inBounds(a) && m != modeImmediate:
+		return Instruction{o, a}, m, CPUbadaddr
+	}
+	return Instruction{o, a}, m, CPUok
+}
+
+// instString formats instruction i, using mode m, for display.
+func instString(i Instruction, m addrMode) string {
+	if p, ok := modePrefix[m]; ok {
+		return fmt.Sprintf("%s %s%02d", i.op, p, i.addr)
+	}
+	return i.String()
+}
+
+// jumps reports whether op transfers control to its address.
+func jumps(op string) bool {
+	return op == "JMP" || op == "CAL" || conditional(op)
+}
+
+// modeSuccessors is successors for an instruction using mode m. The
+// targets of indirect jumps and calls aren't known, so are left out.
+func modeSuccessors(addr int, i Instruction, m addrMode, cs CPUState) []int {
+	next := successors(addr, i, cs)
+	if cs == CPUok && m == modeIndirect && jumps(i.op) {
+		next = next[1:]
+	}
+	return next
+}
+
+// reachable returns the cells that can be reached as instructions by
+// following control flow from entry, decoding them as s does. The
+// targets of indirect jumps aren't known, so aren't followed.
+func (s ISA) reachable(mem *[MemSize]int, entry int) [MemSize]bool {
+	var seen [MemSize]bool
+	todo := []int{entry}
+	for len(todo) > 0 {
+		a := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if !inBounds(a) || seen[a] {
+			continue
+		}
+		seen[a] = true
+		i, m, cs := s.decode(mem[a])
+		todo = append(todo, modeSuccessors(a, i, m, cs)...)
+	}
+	return seen
+}
+
+// WithISA makes the machine decode instructions with isa instead of
+// ISAStandard.
+func WithISA(isa ISA) Option {
+	return func(h *Machine) { h.isa = isa }
+}
+
+// ISA returns the instruction set the machine decodes.
+func (h *Machine) ISA() ISA {
+	return h.isa
+}
@@ -0,0 +1,237 @@
+package machine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestISADecode(t *testing.T) {
+	cases := []struct {
+		isa   ISA
+		word  int
+		want  string // The instruction as displayed, or ??? if it faults
+		state CPUState
+	}{
+		{ISAStandard, 10012, "LAC 012", CPUok},
+		{ISAStandard, 10112, "???", CPUbadaddr},
+		{ISAStandard, 10212, "???", CPUbadaddr},
+		{ISAExtended, 10012, "LAC 012", CPUok},
+		{ISAExtended, 10112, "LAC #12", CPUok},
+		{ISAExtended, 10199, "LAC #99", CPUok},
+		{ISAExtended, 10212, "LAC @12", CPUok},
+		{ISAExtended, 10262, "???", CPUbadaddr},
+		{ISAExtended, 10062, "???", CPUbadaddr},
+		{ISAExtended, 10312, "???", CPUbadinst},
+		{ISAExtended, 11112, "???", CPUbadinst}, // PAC can't store to a value
+		{ISAExtended, 30112, "???", CPUbadinst},
+		{ISAExtended, 5112, "???", CPUbadinst},
+		{ISAExtended, 5212, "JMP @12", CPUok},
+		{ISAExtended, 31107, "PUT #07", CPUok},
+		{ISAExtended, 100, "???", CPUbadinst}, // HLT has no operand to address
+		{ISAExtended, 99000, "???", CPUbadinst},
+		{ISAExtended, -5, "???", CPUbadaddr},
+		{ISAExtended, -105, "???", CPUbadaddr},
+	}
+
+	for i, c := range cases {
+		inst, m, cs := c.isa.decode(c.word)
+		got := "???"
+		if cs == CPUok {
+			got = instString(inst, m)
+		}
+		if got != c.want || cs != c.state {
+			t.Errorf("%02d: %s.decode(%d) = %s, %s; want %s, %s", i, c.isa, c.word, got, cs, c.want, c.state)
+		}
+	}
+}
+
+func TestISADefaultUnchanged(t *testing.T) {
+	for d := -99999; d <= 99999; d++ {
+		i, cs := Decode(d)
+		j, m, ds := ISAStandard.decode(d)
+		if i != j || cs != ds || m != modeDirect {
+			t.Fatalf("ISAStandard.decode(%d) = %v, %d, %s; want %v, %d, %s", d, j, m, ds, i, modeDirect, cs)
+		}
+	}
+}
+
+func TestExtendedStep(t *testing.T) {
+	cases := []struct {
+		prog   string
+		want   string // Output, state, PC, AC and MQ
+		watchW int    // Address of a write watchpoint, if not 0
+	}{
+		// Immediate operands for each instruction that reads a value.
+		{"0: 10107\n1: 20103\n2: 21102\n3: 11010\n4: 31010\n5: 31199\n6: 0", "[8 99] CPUhalt 7 8 0", 0},
+		{"0: 12107\n1: 22106\n2: 23105\n3: 0", "[] CPUhalt 4 2 8", 0},
+		{"0: 12107\n1: 23100\n2: 0", "[] CPUdivzero 2 0 7", 0},
+		// Indirect loads, stores and jumps through a pointer at 10.
+		{"0: 10210\n1: 11211\n2: 0\n10: 20\n11: 21\n20: 42", "[] CPUhalt 3 42 0", 0},
+		{"0: 10210\n1: 11211\n2: 31021\n3: 0\n10: 20\n11: 21\n20: 42", "[42] CPUhalt 4 42 0", 0},
+		{"0: 05210\n1: 0\n10: 12\n12: 31010\n13: 0", "[12] CPUhalt 14 0 0", 0},
+		{"0: 01210\n1: 0\n10: 12\n12: 31010\n13: 0", "[12] CPUhalt 14 0 0", 0},
+		// A pointer outside memory faults without advancing PC.
+		{"0: 10210\n10: 50", "[] CPUbadaddr 0 0 0", 0},
+		{"0: 10210\n10: -1", "[] CPUbadaddr 0 0 0", 0},
+		// Watchpoints see the effective address.
+		{"0: 10107\n1: 11210\n2: 0\n10: 20", "[] CPUpaused 2 7 0", 20},
+		// A store through a pointer into code is seen when it runs.
+		{"0: 10105\n1: 11210\n2: 05003\n3: 10000\n4: 0\n10: 3", "[] CPUhalt 4 5 0", 0},
+	}
+
+	for i, c := range cases {
+		p, err := ParseProgram(strings.NewReader(c.prog))
+		if err != nil {
+			t.Fatalf("%02d: ParseProgram() = %v", i, err)
+		}
+		out := []int{}
+		h := New(WithISA(ISAExtended), WithOutput(func(v int) { out = append(out, v) }), WithConsole(&strings.Builder{}))
+		h.Load(p)
+		if c.watchW != 0 {
+			h.SetWatchpoint(c.watchW, WatchWrite)
+		}
+		h.RunContext(context.Background(), 100)
+
+		got := fmt.Sprintf("%v %s %d %d %d", out, h.state, h.pc, h.ac, h.mq)
+		if got != c.want {
+			t.Errorf("%02d: running %q = %s; want %s", i, c.prog, got, c.want)
+		}
+	}
+}
+
+func TestExtendedStepBack(t *testing.T) {
+	p, err := ParseProgram(strings.NewReader("0: 10107\n1: 11210\n2: 0\n10: 20\n20: 5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(WithISA(ISAExtended), WithHistory(), WithConsole(&strings.Builder{}))
+	h.Load(p)
+	h.Run()
+	if h.mem[20] != 7 {
+		t.Fatalf("mem[20] = %d; want 7", h.mem[20])
+	}
+	h.StepBack()
+	h.StepBack()
+	if h.mem[20] != 5 {
+		t.Errorf("mem[20] after undoing the store = %d; want 5", h.mem[20])
+	}
+}
+
+func TestExtendedDisassemble(t *testing.T) {
+	p, err := Assemble(strings.NewReader("LAC #7 // seven\nPAC @ptr\nJMP @ptr\nptr: DAT 5\nHLT"))
+	if err != nil {
+		t.Fatalf("Assemble() = %v; want nil", err)
+	}
+
+	var b bytes.Buffer
+	if err := p.DisassembleISA(&b, ISAExtended); err != nil {
+		t.Fatal(err)
+	}
+	want := `00:  10107  LAC #07  code  // seven
+01:  11203  PAC @03  code
+02:  05203  JMP @03  code
+03:  00005  HLT 005  data  // ptr:
+04:  00000  HLT 000  data
+`
+	if b.String() != want {
+		t.Errorf("DisassembleISA(ISAExtended) = %q; want %q", b.String(), want)
+	}
+
+	b.Reset()
+	p.Disassemble(&b)
+	if !strings.HasPrefix(b.String(), "00:  10107  ???      code  // seven\n") {
+		t.Errorf("Disassemble() = %q; want the immediate LAC not to decode", b.String())
+	}
+}
+
+func TestExtendedSnapshot(t *testing.T) {
+	h := New(WithISA(ISAExtended), WithConsole(&strings.Builder{}))
+	var b bytes.Buffer
+	if err := h.SaveSnapshot(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `"isa": "extended"`) {
+		t.Errorf("SaveSnapshot() = %s; want an extended isa", b.String())
+	}
+
+	g := New()
+	if err := g.LoadSnapshot(&b); err != nil || g.ISA() != ISAExtended {
+		t.Errorf("LoadSnapshot() = %v with ISA %s; want nil with extended", err, g.ISA())
+	}
+
+	// Standard snapshots don't mention the ISA.
+	b.Reset()
+	New().SaveSnapshot(&b)
+	if strings.Contains(b.String(), "isa") {
+		t.Errorf("SaveSnapshot() = %s; want no isa", b.String())
+	}
+
+	// Snapshots from before the ISA was saved restore the standard one.
+	mem := "[" + strings.Repeat("0, ", MemSize-1) + "0]"
+	if err := g.LoadSnapshot(strings.NewReader(`{"version": 1, "state": "CPUok", "mem": ` + mem + `}`)); err != nil || g.ISA() != ISAStandard {
+		t.Errorf("LoadSnapshot() of version 1 = %v with ISA %s; want nil with standard", err, g.ISA())
+	}
+}
+
+func TestExtendedAnalysis(t *testing.T) {
+	// LAC #5, PAC 10 and PUT @10 write the 42 at 05.
+	p, err := ParseProgram(strings.NewReader("0: 10105\n1: 11010\n2: 31210\n3: 0\n5: 42"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fmt.Sprint(p.LintISA(ISAExtended)); got != "[]" {
+		t.Errorf("LintISA(ISAExtended) = %s; want []", got)
+	}
+	if got := fmt.Sprint(p.Lint()); got != "[line 1: 00: LAC 105 refers to an address outside memory]" {
+		t.Errorf("Lint() = %s; want LAC 105 to refer outside memory", got)
+	}
+
+	g := p.CFGISA(ISAExtended)
+	if got := blockString(g); got != "0-3" {
+		t.Errorf("CFGISA(ISAExtended) = %q; want \"0-3\"", got)
+	}
+	if got := g.lines(g.blocks[0])[2]; got != "02: PUT @10" {
+		t.Errorf("CFGISA(ISAExtended) line 2 = %q; want \"02: PUT @10\"", got)
+	}
+
+	results, complete := p.ExploreISA(ISAExtended, 0, 0)
+	if got := fmt.Sprint(results); !complete || got != "[CPUhalt at 03 with inputs [], output [42]]" {
+		t.Errorf("ExploreISA(ISAExtended) = %s, %t; want [CPUhalt at 03 with inputs [], output [42]], true", got, complete)
+	}
+
+	if err := p.TranspileISA(&bytes.Buffer{}, "modes.hypo", ISAExtended); err != ErrTransModes {
+		t.Errorf("TranspileISA(ISAExtended) = %v; want %v", err, ErrTransModes)
+	}
+
+	// The machine analyses its memory with its own instruction set.
+	h := New(WithISA(ISAExtended), WithConsole(&strings.Builder{}))
+	h.Load(p)
+	if got := fmt.Sprint(h.Lint()); got != "[]" {
+		t.Errorf("Machine.Lint() = %s; want []", got)
+	}
+	if results, _ := h.Explore(0, 0); fmt.Sprint(results) != "[CPUhalt at 03 with inputs [], output [42]]" {
+		t.Errorf("Machine.Explore() = %v; want [CPUhalt at 03 with inputs [], output [42]]", results)
+	}
+
+	// A pointer read from the input is fixed to the path's witness.
+	p, err = ParseProgram(strings.NewReader("0: 30010\n1: 31210\n2: 0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results, _ := p.ExploreISA(ISAExtended, 0, 0); fmt.Sprint(results) != "[CPUhalt at 02 with inputs [0], output [30010]]" {
+		t.Errorf("ExploreISA(ISAExtended) with an input pointer = %v; want [CPUhalt at 02 with inputs [0], output [30010]]", results)
+	}
+
+	// Programs without modes decode the same, so can be transpiled.
+	p, err = ParseProgram(strings.NewReader("0: 31002\n1: 0\n2: 7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.TranspileISA(&bytes.Buffer{}, "plain.hypo", ISAExtended); err != nil {
+		t.Errorf("TranspileISA(ISAExtended) without modes = %v; want nil", err)
+	}
+}
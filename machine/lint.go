@@ -0,0 +1,187 @@
+// This file contains the static linter, which looks for likely
+// mistakes in programs without running them.
+
+package machine
+
+import (
+	"fmt"
+	"sort"
+)
+
+// LintIssue is a likely mistake found in a program by Lint.
+type LintIssue struct {
+	Addr int    // The address of the cell the issue concerns
+	Line int    // The source line that set the cell, or 0 if unknown
+	Msg  string // A description of the issue
+}
+
+func (l LintIssue) String() string {
+	if l.Line == 0 {
+		return fmt.Sprintf("%02d: %s", l.Addr, l.Msg)
+	}
+	return fmt.Sprintf("line %d: %02d: %s", l.Line, l.Addr, l.Msg)
+}
+
+// Lint checks the program for likely mistakes, following control flow
+// from address 0. It reports:
+//
+//   - reachable cells that don't decode to a valid instruction
+//   - code following a HLT, JMP, RET or RTI that can never run
+//   - jumps, calls and trap handlers at cells the program doesn't set,
+//     or that hold constant data
+//   - reads of cells that are neither set by the program nor written
+//   - paths on which the program can never halt, including running off
+//     the end of memory. A RET or RTI is assumed to lead to a HLT, as
+//     the code it returns to isn't known
+//   - addresses set more than once, unless the replaced entries have the
+//     value 0 (the comment line convention)
+//
+// Like the disassembler, Lint can't see changes a program makes to its
+// own instructions, so self-modifying programs may be misjudged. The
+// issues are returned in address order.
+func (p *Program) Lint() []LintIssue {
+	return p.LintISA(ISAStandard)
+}
+
+// LintISA is like Lint, but decodes instructions with isa. The cell an
+// indirect operand points to isn't known, so only the pointer is
+// checked, and an indirect jump is assumed to lead to a HLT.
+func (p *Program) LintISA(isa ISA) []LintIssue {
+	var issues []LintIssue
+	add := func(addr int, format string, args ...interface{}) {
+		issues = append(issues, LintIssue{addr, p.lines[addr], fmt.Sprintf(format, args...)})
+	}
+
+	code := isa.reachable(&p.mem, 0)
+	var inst [MemSize]Instruction
+	var mode [MemSize]addrMode
+	var cs [MemSize]CPUState
+	var read, written [MemSize]bool
+	for a := range p.mem {
+		inst[a], mode[a], cs[a] = isa.decode(p.mem[a])
+		if !code[a] || cs[a] != CPUok {
+			continue
+		}
+		r, w := memAccess(inst[a].op)
+		switch mode[a] {
+		case modeImmediate:
+			continue
+		case modeIndirect:
+			r, w = true, false // The pointer is read
+		}
+		read[inst[a].addr] = read[inst[a].addr] || r
+		written[inst[a].addr] = written[inst[a].addr] || w
+	}
+
+	// canHalt holds the cells from which some path reaches a HLT.
+	var canHalt [MemSize]bool
+	for changed := true; changed; {
+		changed = false
+		for a := range p.mem {
+			if !code[a] || canHalt[a] || cs[a] != CPUok {
+				continue
+			}
+			canHalt[a] = inst[a].op == "HLT" || inst[a].op == "RET" || inst[a].op == "RTI" || (mode[a] == modeIndirect && jumps(inst[a].op))
+			next := modeSuccessors(a, inst[a], mode[a], cs[a])
+			if inst[a].op == "VEC" {
+				next = next[1:] // Installing a handler doesn't run it
+			}
+			for _, s := range next {
+				canHalt[a] = canHalt[a] || canHalt[s]
+			}
+			if canHalt[a] {
+				changed = true
+			}
+		}
+	}
+
+	for a := range p.mem {
+		if !code[a] {
+			continue
+		}
+		i, is := inst[a], instString(inst[a], mode[a])
+
+		switch cs[a] {
+		case CPUbadinst:
+			add(a, "%d is reachable but isn't a valid instruction", p.mem[a])
+			continue
+		case CPUbadaddr:
+			add(a, "%s refers to an address outside memory", is)
+			continue
+		}
+
+		if (jumps(i.op) || i.op == "VEC") && mode[a] == modeDirect {
+			verb := map[string]string{"CAL": "calls", "VEC": "installs a handler at"}[i.op]
+			if verb == "" {
+				verb = "jumps to"
+			}
+			switch t := i.addr; {
+			case !p.used[t]:
+				add(a, "%s %s %02d, which the program doesn't set", is, verb, t)
+			case read[t] && !written[t]:
+				add(a, "%s %s %02d, which holds data", is, verb, t)
+			}
+		}
+
+		if r, _ := memAccess(i.op); ((r && mode[a] == modeDirect) || mode[a] == modeIndirect) && !p.used[i.addr] && !written[i.addr] {
+			add(a, "%s reads %02d, which is never set or written", is, i.addr)
+		}
+
+		if i.op == "HLT" || i.op == "JMP" || i.op == "RET" || i.op == "RTI" {
+			for b := a + 1; inBounds(b) && p.used[b] && !code[b] && !read[b] && !written[b]; b++ {
+				add(b, "unreachable code following %s at %02d", is, a)
+			}
+		}
+
+		if a == MemSize-1 && i.op != "HLT" && i.op != "JMP" && i.op != "RET" && i.op != "RTI" {
+			add(a, "execution can run past the end of memory")
+		}
+
+		if !canHalt[a] && (a == 0 || p.haltingPredecessor(a, &code, &canHalt, &inst, &mode)) {
+			add(a, "no path from here reaches a HLT")
+		}
+	}
+
+	for _, o := range p.overwritten {
+		if o.value != 0 {
+			issues = append(issues, LintIssue{o.addr, o.line, fmt.Sprintf("value %d is replaced by line %d; only entries with value 0 are treated as comment lines", o.value, o.by)})
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Addr != issues[j].Addr {
+			return issues[i].Addr < issues[j].Addr
+		}
+		return issues[i].Line < issues[j].Line
+	})
+	return issues
+}
+
+// haltingPredecessor reports whether a cell that can reach a HLT can
+// continue at addr. This finds where paths commit to never halting,
+// so a loop is reported once instead of at every cell in it.
+func (p *Program) haltingPredecessor(addr int, code, canHalt *[MemSize]bool, inst *[MemSize]Instruction, mode *[MemSize]addrMode) bool {
+	for a := range p.mem {
+		if !code[a] || !canHalt[a] {
+			continue
+		}
+		for _, s := range modeSuccessors(a, inst[a], mode[a], CPUok) {
+			if s == addr {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Lint checks the machine's memory for likely mistakes, as
+// Program.LintISA does with the machine's instruction set. Cells set
+// by the loaded program, or holding a non-zero value, are treated as
+// set.
+func (h *Machine) Lint() []LintIssue {
+	p := h.program()
+	for a := range p.used {
+		p.used[a] = h.mem[a] != 0 || (h.prog != nil && h.prog.used[a])
+	}
+	return p.LintISA(h.isa)
+}
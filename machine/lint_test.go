@@ -0,0 +1,79 @@
+package machine
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	cases := []struct {
+		prog string
+		want []string
+	}{
+		{"0: 31002\n1: 0\n2: 7", nil},
+		{"0: 30010\n1: 31010\n2: 0", nil},
+		{"0: 99000", []string{"line 1: 00: 99000 is reachable but isn't a valid instruction"}},
+		{"0: 10050\n1: 0", []string{"line 1: 00: LAC 050 refers to an address outside memory"}},
+		{"0: 0\n1: 31001\n2: 31001", []string{"line 2: 01: unreachable code following HLT 000 at 00", "line 3: 02: unreachable code following HLT 000 at 00"}},
+		{"0: 05010", []string{"line 1: 00: JMP 010 jumps to 10, which the program doesn't set"}},
+		{"0: 10003\n1: 01003\n2: 0\n3: 5", []string{"line 2: 01: JEQ 003 jumps to 03, which holds data"}},
+		{"0: 31010\n1: 0", []string{"line 1: 00: PUT 010 reads 10, which is never set or written"}},
+		{"0: 31002\n1: 05000\n2: 7", []string{"line 1: 00: no path from here reaches a HLT"}},
+		{"0: 30010\n1: 10010\n2: 01004\n3: 0\n4: 05004", []string{"line 5: 04: no path from here reaches a HLT"}},
+		{"0: 08003\n1: 0\n2: 31001\n3: 09000", []string{"line 3: 02: unreachable code following HLT 000 at 01"}},
+		{"0: 08003\n1: 31003\n2: 0\n3: 7", []string{"line 1: 00: CAL 003 calls 03, which holds data"}},
+		{"0: 08010\n1: 0\n10: 09000\n11: 31000", []string{"line 4: 11: unreachable code following RET 000 at 10"}},
+		{"0: 14002\n1: 0\n2: 04000\n3: 31000", []string{"line 4: 03: unreachable code following RTI 000 at 02"}},
+		{"0: 14003\n1: 0", []string{"line 1: 00: VEC 003 installs a handler at 03, which the program doesn't set"}},
+		{"0: 14002\n1: 05001\n2: 04000", []string{"line 1: 00: no path from here reaches a HLT"}},
+		{"0: 05049\n48: 7\n49: 31048", []string{"line 1: 00: no path from here reaches a HLT", "line 3: 49: execution can run past the end of memory"}},
+		{"0: 0 // c\n0: 0 // c\n0: 31001\n0: 31002\n1: 0\n2: 0", []string{"line 3: 00: value 31001 is replaced by line 4; only entries with value 0 are treated as comment lines"}},
+	}
+
+	for i, c := range cases {
+		p, err := ParseProgram(strings.NewReader(c.prog))
+		if err != nil {
+			t.Fatalf("%02d: ParseProgram(%q) = %v; want nil", i, c.prog, err)
+		}
+		var got []string
+		for _, l := range p.Lint() {
+			got = append(got, l.String())
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("%02d: Lint(%q) = %q; want %q", i, c.prog, got, c.want)
+		}
+	}
+}
+
+func TestLintExamples(t *testing.T) {
+	for _, f := range []string{"fibonacci.hypo", "max.hypo", "quine.hypo", "simple_quine.hypo", "subroutine.hypo", "divide.hypo"} {
+		r, err := os.Open("../examples/" + f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		p, err := ParseProgram(r)
+		if err != nil {
+			t.Fatalf("ParseProgram(%s) = %v; want nil", f, err)
+		}
+		if issues := p.Lint(); len(issues) != 0 {
+			t.Errorf("Lint(%s) = %v; want no issues", f, issues)
+		}
+	}
+}
+
+func TestMachineLint(t *testing.T) {
+	h := New(WithConsole(&strings.Builder{}))
+	if err := h.LoadProgram(strings.NewReader("0: 31010\n1: 31011\n2: 0\n11: 0")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cell 11 is set by the program, even though it holds 0.
+	want := "[line 1: 00: PUT 010 reads 10, which is never set or written]"
+	if got := fmt.Sprint(h.Lint()); got != want {
+		t.Errorf("h.Lint() = %s; want %s", got, want)
+	}
+}
@@ -0,0 +1,623 @@
+/* Package machine implements hypo, the Hypothetical Machine, as a
+library. See README.md for a description of the machine.  */
+package machine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// inBounds validates whether an address is valid or not.
+func inBounds(addr int) bool {
+	return addr >= 0 && addr < MemSize
+}
+
+// boundsCap implements integer bounds capping. The hypo machine
+// allows for values in the range [-99999, 99999].
+func boundsCap(i int) int {
+	if i > 99999 {
+		return 99999
+	}
+
+	if i < -99999 {
+		return -99999
+	}
+
+	return i
+}
+
+// The Hypo machine has 50 memory addresses
+const MemSize = 50
+
+// StackSize is the number of return addresses the return stack holds.
+const StackSize = 8
+
+// Errors held by the LoadError that ParseProgram and LoadProgram
+// return.
+var (
+	ErrLoadBadFile  = errors.New("Invalid program file")
+	ErrLoadBadLine  = errors.New("Invalid line in program")
+	ErrLoadBadAddr  = errors.New("Invalid memory address - can't load data there")
+	ErrLoadBadValue = errors.New("Invalid value - couldn't parse")
+)
+
+// CPUState indicates whether the Hypo machine can continue operating
+// or needs to be reset for various reasons.
+type CPUState int
+
+const (
+	CPUok      = iota // Program execution may continue
+	CPUbadinst = iota // Invalid instruction
+	CPUbadaddr = iota // Invalid memory reference
+	CPUdivzero = iota // Divide by zero
+	CPUhalt    = iota // Halted
+	CPUpaused  = iota // Stopped by a breakpoint or watchpoint; execution may continue
+	CPUstack   = iota // Return stack overflow or underflow
+	CPUio      = iota // Input failed or ran out
+)
+
+func (s CPUState) String() string {
+	switch s {
+	case CPUok:
+		return "CPUok"
+	case CPUbadaddr:
+		return "CPUbadaddr"
+	case CPUdivzero:
+		return "CPUdivzero"
+	case CPUbadinst:
+		return "CPUbadinst"
+	case CPUhalt:
+		return "CPUhalt"
+	case CPUpaused:
+		return "CPUpaused"
+	case CPUstack:
+		return "CPUstack"
+	case CPUio:
+		return "CPUio"
+	default:
+		return ("Unknown CPU state.")
+	}
+}
+
+// ParseCPUState returns the CPUState named s, as written by String.
+func ParseCPUState(s string) (CPUState, bool) {
+	for cs := CPUState(CPUok); cs <= CPUio; cs++ {
+		if cs.String() == s {
+			return cs, true
+		}
+	}
+	return 0, false
+}
+
+// Instruction is a decoded memory word: an operation and the address
+// it refers to.
+type Instruction struct {
+	op   string
+	addr int
+}
+
+// Op returns the instruction's mnemonic, or UNK if the word didn't
+// hold a valid opcode.
+func (i Instruction) Op() string {
+	return i.op
+}
+
+// Addr returns the memory address the instruction refers to.
+func (i Instruction) Addr() int {
+	return i.addr
+}
+
+// String ensures that Instruction implements the Stringer interface
+// for easy display.
+func (i Instruction) String() string {
+	return fmt.Sprintf("%s %03d", i.op, i.addr)
+}
+
+// The op codes with textual equivalents.
+var ops = map[int]string{
+	0:  "HLT", // HALT
+	1:  "JEQ", // Jump to addr if AC == 0
+	2:  "JGT", // Jump to addr if AC > 0
+	3:  "JLT", // Jump to addr if AC < 0
+	4:  "RTI", // Return from a trap handler to the saved PC
+	5:  "JMP", // Jump to addr
+	6:  "JLE", // Jump to addr if AC <= 0
+	7:  "JNE", // Jump to addr if AC != 0
+	8:  "CAL", // Push the address of the next instruction on the return stack and jump to addr
+	9:  "RET", // Pop an address from the return stack and jump to it
+	10: "LAC", // Load addr into AC, the accumulator
+	11: "PAC", // Put AC to addr
+	12: "LMQ", // Load addr into MQ, the multipler-quotient
+	13: "PMQ", // Put MQ to addr
+	14: "VEC", // Install addr as the handler for the trap numbered by AC
+	15: "LEP", // Load addr into EPC, the saved PC
+	16: "PEP", // Put EPC to addr
+	20: "ADD", // Add addr to AC
+	21: "SUB", // Subtract addr from AC
+	22: "MUL", // Multiply MQ by the content of addr
+	23: "DIV", // Divide MQ by the content of addr. The remainder is in AC.
+	30: "GET", // Read input to addr
+	31: "PUT", // Output addr
+	33: "CHN", // Select the I/O channel numbered by the contents of addr for GET, PUT, GCH and PCH
+	34: "GCH", // Read a character's code to addr
+	35: "PCH", // Output the character whose code is in addr
+}
+
+// A Getter is a generic function that returns an integer value from
+// the user, or an error if it can't. It returns io.EOF once the input
+// has run out.
+type Getter func() (int, error)
+
+// stdinNumbers reads the numbers typed for Input.
+var stdinNumbers = NumberGetter(Stdin)
+
+// A default Getter, reading a number from Stdin after a prompt.
+var Input Getter = func() (int, error) {
+	fmt.Printf("Enter a numeric value: ")
+	return stdinNumbers()
+}
+
+// A Putter is a generic function that accepts an integer and
+// (presumably) displays it to the user somehow.
+type Putter func(int)
+
+// A default Putter
+var Output = func(i int) {
+	fmt.Printf("% 06d\n", i)
+}
+
+// Machine represents all register, memory, state and I/O objects
+// required to implement a "Hypothetical Machine".
+type Machine struct {
+	mem   [MemSize]int         // Instructions and data aren't distinguishable by anything other than a valid opcode and address when "parsed".
+	pc    int                  // program counter
+	ac    int                  // accumulator
+	mq    int                  // mulitplier quotient
+	state CPUState             // The program should stop
+	chans [NumChannels]channel // Our ears and mouths, one pair per channel
+	ch    int                  // The channel GET and PUT use
+	trace bool                 // If true, instructions will be displayed at execution time.
+	prog  *Program             // The most recently loaded program, if any
+	isa   ISA                  // How instructions are decoded
+	stack []int                // Return addresses pushed by CAL, innermost last
+	traps trapState            // Trap handlers, the saved PC and pending interrupts
+	timer int                  // Raise TrapTimer after this many instructions, if > 0
+
+	inPolicy  InputPolicy // What GET and GCH do when input fails
+	inDefault int         // The value read in place of failed input under InputDefault
+	inErr     error       // The error that stopped the machine with CPUio
+
+	devs    [MemSize]*mapping // The device mapped at each address, if any
+	tickers []Ticker          // Mapped devices that are told about each instruction
+
+	console io.Writer // Where diagnostics, traces and dumps are written
+	optMsgs []string  // Problems with the options given to New, for it to report
+	prof    *Profile  // Collects execution counts while profiling, or nil
+	cover   *Coverage // Collects code coverage, or nil
+
+	breaks    []*breakpoint // Breakpoints and watchpoints
+	nextBreak int           // The id of the most recently added breakpoint
+	hit       *breakpoint   // The breakpoint or watchpoint that paused execution
+	hist      history       // Recent steps, so they can be undone
+	recording bool          // Whether steps are recorded in hist
+
+	cells [MemSize]cell // Decoded memory, filled in as cells are executed
+}
+
+// An Option configures a Machine created by New.
+type Option func(*Machine)
+
+// WithInput makes the machine read GET values on channel 0 from g
+// instead of Input.
+func WithInput(g Getter) Option {
+	return func(h *Machine) { h.chans[0].in = g }
+}
+
+// WithOutput makes the machine send PUT values on channel 0 to p
+// instead of Output.
+func WithOutput(p Putter) Option {
+	return func(h *Machine) { h.chans[0].out = p }
+}
+
+// WithCharInput makes the machine read GCH values on channel 0 from g
+// instead of CharInput.
+func WithCharInput(g Getter) Option {
+	return func(h *Machine) { h.chans[0].cin = g }
+}
+
+// WithCharOutput makes the machine send PCH values on channel 0 to p
+// instead of CharOutput.
+func WithCharOutput(p Putter) Option {
+	return func(h *Machine) { h.chans[0].cout = p }
+}
+
+// WithConsole sends diagnostics, traces and the output of the Dump
+// methods to w instead of stdout.
+func WithConsole(w io.Writer) Option {
+	return func(h *Machine) { h.console = w }
+}
+
+// New returns an initialized machine. It wires up Input, Output,
+// CharInput and CharOutput for i/o on channel 0 and stdout for
+// diagnostics unless options override them.
+func New(opts ...Option) *Machine {
+	h := &Machine{console: os.Stdout}
+	h.chans[0] = channel{Input, Output, CharInput, CharOutput}
+	for _, o := range opts {
+		o(h)
+	}
+	// Options may come in any order, so they can't report problems
+	// until the console is known.
+	for _, e := range h.optMsgs {
+		fmt.Fprintln(h.console, e)
+	}
+	h.optMsgs = nil
+	return h
+}
+
+// PC returns the program counter.
+func (h *Machine) PC() int {
+	return h.pc
+}
+
+// AC returns the accumulator.
+func (h *Machine) AC() int {
+	return h.ac
+}
+
+// MQ returns the multiplier-quotient register.
+func (h *Machine) MQ() int {
+	return h.mq
+}
+
+// State returns the CPU state.
+func (h *Machine) State() CPUState {
+	return h.state
+}
+
+// Stack returns a copy of the return stack, innermost address last.
+func (h *Machine) Stack() []int {
+	return append([]int{}, h.stack...)
+}
+
+// Memory returns a copy of the machine's memory.
+func (h *Machine) Memory() [MemSize]int {
+	return h.mem
+}
+
+// Tracing returns true if instructions are displayed as they execute.
+func (h *Machine) Tracing() bool {
+	return h.trace
+}
+
+// getInstruction returns the instruction stored at addr if addr is in
+// bounds and represents a valid instruction. If the opcode or target
+// address of the instruction is out of bounds, the returned CPUState
+// will be set appropriately.
+func (h *Machine) getInstruction(addr int) (Instruction, CPUState) {
+	if !inBounds(addr) {
+		return Instruction{"UNK", 0}, CPUbadinst
+	}
+
+	return Decode(h.mem[addr])
+}
+
+// Decode interprets the memory word d as an instruction. If the
+// opcode or target address is out of bounds, the returned CPUState
+// will be set appropriately.
+func Decode(d int) (Instruction, CPUState) {
+	op := d / 1000
+	a := d % 1000
+	o, ok := ops[op]
+	if !ok {
+		return Instruction{"UNK", a}, CPUbadinst
+	} else if !inBounds(a) {
+		return Instruction{o, a}, CPUbadaddr
+	}
+
+	return Instruction{o, a}, CPUok
+}
+
+// jumpTaken reports whether the jump instruction op transfers control
+// to its target address when the accumulator holds ac. It is false
+// for all other instructions.
+func jumpTaken(op string, ac int) bool {
+	switch op {
+	case "JEQ":
+		return ac == 0
+	case "JGT":
+		return ac > 0
+	case "JLT":
+		return ac < 0
+	case "JMP":
+		return true
+	case "JLE":
+		return ac <= 0
+	case "JNE":
+		return ac != 0
+	}
+	return false
+}
+
+// LoadProgram reads a program in the addr: value format from r and
+// loads it into memory. The CPU is left halted if the program can't
+// be loaded, and the returned error is a *LoadError.
+func (h *Machine) LoadProgram(r io.Reader) error {
+	// Ensure the machine is halted until we signal a clean load below.
+	h.state = CPUhalt
+	// Reset machine memory so a failed load leaves nothing behind.
+	h.mem = [MemSize]int{}
+	h.flushCells()
+	h.prog = nil
+	h.hist.clear()
+
+	p, err := ParseProgram(r)
+	if err != nil {
+		return err
+	}
+
+	h.Load(p)
+	fmt.Fprintln(h.console, "Program loaded successfully.")
+	return nil
+}
+
+// Load replaces memory with the program's memory image and marks the
+// CPU ok. The program is retained so its source comments and labels
+// can be used when reporting on the machine.
+func (h *Machine) Load(p *Program) {
+	h.mem = p.mem
+	h.flushCells()
+	h.prog = p
+	h.state = CPUok
+	h.hist.clear()
+}
+
+// Step executes the instruction in the memory address referenced by
+// PC (program counter). If it is valid, the machine state is alerted
+// accordingly, otherwise the CPU state is transitioned to an
+// appropriate !ok value. If the instruction triggers a watchpoint, the
+// CPU is left paused.
+func (h *Machine) Step() {
+	if h.traps.pending != 0 && !h.traps.handling {
+		h.interrupt()
+		return
+	}
+
+	c := h.fetch(h.pc)
+	addr, cs := c.inst.addr, c.cs
+	var inErr error
+	if cs == CPUok && c.mode == modeIndirect {
+		if addr, inErr = h.load(addr); inErr != nil {
+			cs = CPUio
+		} else if !inBounds(addr) {
+			cs = CPUbadaddr
+		}
+	}
+	if h.recording {
+		h.record(c, addr, cs)
+	}
+
+	if h.state == CPUpaused {
+		h.state = CPUok
+	}
+	h.hit = nil
+
+	if inErr != nil {
+		h.ioFault(inErr)
+		return
+	}
+	if cs != CPUok {
+		h.fault(cs)
+		return
+	}
+
+	if h.trace {
+		fmt.Fprintln(h.console, instString(c.inst, c.mode))
+	}
+
+	if h.prof != nil {
+		h.prof.add(h.pc, c.inst, h.ac)
+	}
+	if h.cover != nil {
+		h.cover.add(h.pc, c.inst, h.ac)
+	}
+
+	h.pc += 1
+
+	// An immediate operand is the address itself. Devices are only
+	// read by instructions that read a value.
+	v, old := addr, 0
+	if c.read {
+		var err error
+		if old, err = h.load(addr); err != nil {
+			h.pc--
+			h.ioFault(err)
+			return
+		}
+		v = old
+	} else if c.write {
+		old = h.mem[addr]
+	}
+	c.exec(h, addr, v)
+	if h.timer > 0 {
+		h.tick()
+	}
+	for _, t := range h.tickers {
+		t.Tick()
+	}
+
+	if c.read || c.write {
+		if b := h.checkWatch(addr, c.read, c.write, old); b != nil && h.state == CPUok {
+			h.hit = b
+			h.state = CPUpaused
+		}
+	}
+}
+
+// Halted returns true if the system state is such that execution
+// cannot continue. This is any state other than CPUok and CPUpaused.
+func (h *Machine) Halted() bool {
+	return h.state != CPUok && h.state != CPUpaused
+}
+
+// Paused returns true if execution was stopped by a breakpoint or
+// watchpoint and may be continued.
+func (h *Machine) Paused() bool {
+	return h.state == CPUpaused
+}
+
+// Reset restores the CPU to initial state (all registers 0, program
+// counter 0 and CPU state OK).
+func (h *Machine) ResetCPU() {
+	h.ac = 0
+	h.mq = 0
+	h.pc = 0
+	h.stack = nil
+	h.traps = trapState{}
+	h.ch = 0
+	h.state = CPUok
+	h.hit = nil
+	h.hist.clear()
+	fmt.Fprintln(h.console, "CPU state reset.")
+}
+
+// DumpMem prints memory content to the console.
+func (h *Machine) DumpMem() {
+	for i, c := range h.mem {
+		fmt.Fprintf(h.console, "%02d: % 06d  ", i, c)
+		if i%5 == 4 {
+			fmt.Fprintf(h.console, "\n")
+		}
+	}
+}
+
+// DumpRegs prints register content to the console.
+func (h *Machine) DumpRegs() {
+	fmt.Fprintf(h.console, "PC: %02d  AC: % 06d  MQ: % 06d\n", h.pc, h.ac, h.mq)
+}
+
+// DumpStack prints the return stack to the console.
+func (h *Machine) DumpStack() {
+	if len(h.stack) == 0 {
+		fmt.Fprintln(h.console, "(empty)")
+		return
+	}
+	for i, a := range h.stack {
+		if i > 0 {
+			fmt.Fprint(h.console, " ")
+		}
+		fmt.Fprintf(h.console, "%02d", a)
+	}
+	fmt.Fprintln(h.console)
+}
+
+// DumpState prints memory, register and cpu state to the console.
+func (h *Machine) DumpState() {
+	fmt.Fprintln(h.console, "Memory:")
+	h.DumpMem()
+	fmt.Fprintln(h.console)
+	fmt.Fprintln(h.console, "Registers:")
+	h.DumpRegs()
+	fmt.Fprintln(h.console)
+	fmt.Fprintln(h.console, "Return stack (innermost last):")
+	h.DumpStack()
+	fmt.Fprintln(h.console)
+	fmt.Fprintln(h.console, "Trap handlers:")
+	h.DumpTraps()
+	fmt.Fprintln(h.console)
+	fmt.Fprintf(h.console, "I/O channel: %d\n", h.ch)
+	fmt.Fprintf(h.console, "CPU State: %s\n\n", h.state)
+}
+
+// StopReason explains why RunContext returned.
+type StopReason int
+
+const (
+	StopHalted    StopReason = iota // A HLT instruction was executed
+	StopFaulted                     // The CPU entered a fault state
+	StopPaused                      // A breakpoint or watchpoint paused execution
+	StopBudget                      // The step budget was used up
+	StopCancelled                   // The context was cancelled
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopHalted:
+		return "halted"
+	case StopFaulted:
+		return "faulted"
+	case StopPaused:
+		return "paused"
+	case StopBudget:
+		return "step budget exhausted"
+	case StopCancelled:
+		return "cancelled"
+	default:
+		return "unknown stop reason"
+	}
+}
+
+// ctxCheckSteps is how many steps RunContext executes between checks
+// for cancellation.
+const ctxCheckSteps = 64
+
+// Run executes instructions until the CPU enters a halted state or
+// pauses at a breakpoint or watchpoint. The instruction at PC when Run
+// is called is always executed, so calling Run again continues a
+// paused program.
+func (h *Machine) Run() {
+	if r, _ := h.RunContext(context.Background(), 0); r == StopPaused {
+		fmt.Fprintf(h.console, "Program paused by %s\n", h.hit)
+		return
+	}
+	if h.state == CPUio {
+		fmt.Fprintf(h.console, "Error reading input: %v\n", h.inErr)
+	}
+	fmt.Fprintf(h.console, "Program terminated with: %q\n", h.state)
+}
+
+// RunContext executes instructions like Run, but also stops once
+// maxSteps instructions have been executed (if maxSteps > 0) or ctx is
+// cancelled, whichever comes first. A cancelled run returns ctx.Err().
+// Stopping for the budget or cancellation leaves the CPU ok, so the
+// run may be continued. A GET that is waiting on input can't be
+// cancelled, and one whose input fails leaves the CPU in CPUio unless
+// the input policy says otherwise.
+func (h *Machine) RunContext(ctx context.Context, maxSteps int) (StopReason, error) {
+	for n := 0; ; n++ {
+		if n%ctxCheckSteps == 0 {
+			if err := ctx.Err(); err != nil {
+				return StopCancelled, err
+			}
+		}
+		if maxSteps > 0 && n >= maxSteps {
+			return StopBudget, nil
+		}
+
+		if b := h.atBreakpoint(h.pc); b != nil && n > 0 {
+			h.hit = b
+			h.state = CPUpaused
+		} else {
+			h.Step()
+		}
+
+		switch {
+		case h.Paused():
+			return StopPaused, nil
+		case h.state == CPUhalt:
+			return StopHalted, nil
+		case h.Halted():
+			return StopFaulted, nil
+		}
+	}
+}
+
+// ToggleTrace switches the display of instructions as they execute on
+// or off.
+func (h *Machine) ToggleTrace() {
+	h.trace = !h.trace
+	fmt.Fprintln(h.console, "Tracing mode:", h.trace)
+}
@@ -1,9 +1,15 @@
-package main
+package machine
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetInstruction(t *testing.T) {
@@ -30,13 +36,14 @@ func TestGetInstruction(t *testing.T) {
 		{23022, Instruction{"DIV", 22}, CPUok},
 		{30031, Instruction{"GET", 31}, CPUok},
 		{31032, Instruction{"PUT", 32}, CPUok},
-		{1000 + memSize, Instruction{"JEQ", memSize}, CPUbadaddr},  // Valid opcode, invalid memory address.
-		{31000 + memSize, Instruction{"PUT", memSize}, CPUbadaddr}, // Valid opcode, invalid memory address.
+		{33033, Instruction{"CHN", 33}, CPUok},
+		{1000 + MemSize, Instruction{"JEQ", MemSize}, CPUbadaddr},  // Valid opcode, invalid memory address.
+		{31000 + MemSize, Instruction{"PUT", MemSize}, CPUbadaddr}, // Valid opcode, invalid memory address.
 		{32000, Instruction{"UNK", 0}, CPUbadinst},                 // Invalid opcode.
-		{33000, Instruction{"UNK", 0}, CPUbadinst},                 // Invalid opcode.
+		{40000, Instruction{"UNK", 0}, CPUbadinst},                 // Invalid opcode.
 	}
 
-	h := NewMachine()
+	h := New()
 	for i, c := range cases {
 		h.mem[0] = c.input
 		got, cs := h.getInstruction(0)
@@ -45,7 +52,7 @@ func TestGetInstruction(t *testing.T) {
 		}
 	}
 
-	bad := []int{-1, memSize, memSize + 1}
+	bad := []int{-1, MemSize, MemSize + 1}
 	u := Instruction{"UNK", 0}
 	for i, b := range bad {
 		if got, cs := h.getInstruction(b); !reflect.DeepEqual(got, u) || cs != CPUbadinst {
@@ -57,82 +64,82 @@ func TestGetInstruction(t *testing.T) {
 func TestLoadProgram(t *testing.T) {
 	cases := []struct {
 		prog      string
-		want      [memSize]int
+		want      [MemSize]int
 		wantErr   error
 		wantState CPUState
 	}{
 		{
 			"0: 31000", // No comment
-			[memSize]int{31000, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			[MemSize]int{31000, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
 			nil,
 			CPUok,
 		},
 		{
 			"0: 31000    ", // trailing whitespace, no formal comment
-			[memSize]int{31000, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			[MemSize]int{31000, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
 			nil,
 			CPUok,
 		},
 		{
 			"0: 31000 // A comment",
-			[memSize]int{31000, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			[MemSize]int{31000, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
 			nil,
 			CPUok,
 		},
 		{
 			"0: 31000 // Ok\n49: 21000 // Bookends",
-			[memSize]int{31000, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 21000},
+			[MemSize]int{31000, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 21000},
 			nil,
 			CPUok,
 		},
 		{
 			"0: 31000 // Ok\n0: 21000 // Overwritten",
-			[memSize]int{21000, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			[MemSize]int{21000, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
 			nil,
 			CPUok,
 		},
 		{
 			"1: 31000 // Ok\n0: 21000 // Out of order",
-			[memSize]int{21000, 31000, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			[MemSize]int{21000, 31000, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
 			nil,
 			CPUok,
 		},
 		{
 			"1: -5 // Ok, negative  numbers",
-			[memSize]int{0, -5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			[MemSize]int{0, -5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
 			nil,
 			CPUok,
 		},
 		{
 			": 31000 // Bad line",
-			[memSize]int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-			loadErrBadLine,
+			[MemSize]int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			ErrLoadBadLine,
 			CPUhalt,
 		},
 		{
 			"1: 31aasdf // Bad line",
-			[memSize]int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-			loadErrBadLine,
+			[MemSize]int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			ErrLoadBadLine,
 			CPUhalt,
 		},
 		{
 			"50: 31000 // Bad address",
-			[memSize]int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-			loadErrBadAddr,
+			[MemSize]int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			ErrLoadBadAddr,
 			CPUhalt,
 		},
 		{
 			"a: 31000 // Bad address, makes the line bad",
-			[memSize]int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-			loadErrBadLine,
+			[MemSize]int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			ErrLoadBadLine,
 			CPUhalt,
 		},
 	}
 
 	for i, c := range cases {
-		h := NewMachine()
+		h := New()
 		err := h.LoadProgram(strings.NewReader(c.prog))
-		if err != c.wantErr {
+		if !errors.Is(err, c.wantErr) {
 			t.Errorf("%02d: h.LoadProgram(blah) = %v; want %v", i, err, c.wantErr)
 		}
 
@@ -141,13 +148,13 @@ func TestLoadProgram(t *testing.T) {
 		}
 
 		if h.state != c.wantState {
-			t.Errorf("%02d: h.state = %q; want %q", h.state, c.wantState)
+			t.Errorf("%02d: h.state = %q; want %q", i, h.state, c.wantState)
 		}
 	}
 }
 
 func TestHLT(t *testing.T) {
-	h := NewMachine()
+	h := New()
 	if h.state != CPUok || h.pc != 0 || h.mem[0] != 0 {
 		t.Error("Invalid initial state for machine.")
 	}
@@ -172,7 +179,7 @@ func TestJEQ(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		h := NewMachine()
+		h := New()
 		h.pc = c.pc
 		h.mem[c.pc] = c.inst
 		h.ac = c.ac
@@ -195,7 +202,7 @@ func TestJGT(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		h := NewMachine()
+		h := New()
 		h.pc = c.pc
 		h.mem[c.pc] = c.inst
 		h.ac = c.ac
@@ -218,7 +225,7 @@ func TestJLT(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		h := NewMachine()
+		h := New()
 		h.pc = c.pc
 		h.mem[c.pc] = c.inst
 		h.ac = c.ac
@@ -240,7 +247,7 @@ func TestJMP(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		h := NewMachine()
+		h := New()
 		h.pc = c.pc
 		h.mem[c.pc] = c.inst
 		h.Step()
@@ -263,7 +270,7 @@ func TestJLE(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		h := NewMachine()
+		h := New()
 		h.pc = c.pc
 		h.mem[c.pc] = c.inst
 		h.ac = c.ac
@@ -287,7 +294,7 @@ func TestJNE(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		h := NewMachine()
+		h := New()
 		h.pc = c.pc
 		h.mem[c.pc] = c.inst
 		h.ac = c.ac
@@ -298,6 +305,75 @@ func TestJNE(t *testing.T) {
 	}
 }
 
+func TestCAL(t *testing.T) {
+	cases := []struct {
+		depth  int // Return addresses on the stack before the test
+		inst   int
+		wantPC int
+		state  CPUState
+	}{
+		{0, 8010, 10, CPUok},
+		{StackSize - 1, 8020, 20, CPUok},
+		{StackSize, 8010, 1, CPUstack}, // Overflow
+	}
+
+	for i, c := range cases {
+		h := New()
+		for n := 0; n < c.depth; n++ {
+			h.stack = append(h.stack, 40)
+		}
+		h.mem[0] = c.inst
+		h.Step()
+		if h.pc != c.wantPC || h.state != c.state {
+			t.Errorf("%02d: Stepped machine across CAL. pc = %d, state = %s; wanted %d, %s", i, h.pc, h.state, c.wantPC, c.state)
+		}
+		if c.state == CPUok && (len(h.stack) != c.depth+1 || h.stack[c.depth] != 1) {
+			t.Errorf("%02d: Stepped machine across CAL. stack = %v; wanted 01 pushed", i, h.stack)
+		}
+	}
+}
+
+func TestRET(t *testing.T) {
+	cases := []struct {
+		stack  []int
+		wantPC int
+		state  CPUState
+	}{
+		{[]int{7}, 7, CPUok},
+		{[]int{7, 12}, 12, CPUok},
+		{nil, 1, CPUstack}, // Underflow
+	}
+
+	for i, c := range cases {
+		h := New()
+		h.stack = append([]int{}, c.stack...)
+		h.mem[0] = 9000
+		h.Step()
+		if h.pc != c.wantPC || h.state != c.state {
+			t.Errorf("%02d: Stepped machine across RET. pc = %d, state = %s; wanted %d, %s", i, h.pc, h.state, c.wantPC, c.state)
+		}
+	}
+}
+
+func TestSubroutineExample(t *testing.T) {
+	var out []int
+	in := []int{3, -4, 0}
+	h := New(WithInput(func() (int, error) { v := in[0]; in = in[1:]; return v, nil }), WithOutput(func(i int) { out = append(out, i) }), WithConsole(&strings.Builder{}))
+	f, err := os.Open("../examples/subroutine.hypo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := h.LoadProgram(f); err != nil {
+		t.Fatalf("h.LoadProgram() = %v; want nil", err)
+	}
+
+	h.Run()
+	if h.state != CPUhalt || fmt.Sprint(out) != "[3 4 0]" || len(h.Stack()) != 0 {
+		t.Errorf("Running subroutine.hypo = %s with output %v and stack %v; want CPUhalt with [3 4 0] and an empty stack", h.state, out, h.Stack())
+	}
+}
+
 func TestLAC(t *testing.T) {
 	cases := []struct {
 		inst int
@@ -308,7 +384,7 @@ func TestLAC(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		h := NewMachine()
+		h := New()
 		h.mem[h.pc] = c.inst
 		h.Step()
 		if h.ac != c.want {
@@ -328,7 +404,7 @@ func TestPAC(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		h := NewMachine()
+		h := New()
 		h.ac = c.ac
 		h.mem[h.pc] = c.inst
 		h.Step()
@@ -348,7 +424,7 @@ func TestLMQ(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		h := NewMachine()
+		h := New()
 		h.mem[h.pc] = c.inst
 		h.Step()
 		if h.mq != c.want {
@@ -368,7 +444,7 @@ func TestPMQ(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		h := NewMachine()
+		h := New()
 		h.mq = c.mq
 		h.mem[h.pc] = c.inst
 		h.Step()
@@ -392,7 +468,7 @@ func TestADD(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		h := NewMachine()
+		h := New()
 		h.ac = c.ac
 		h.mem[h.pc] = c.inst
 		h.Step()
@@ -416,7 +492,7 @@ func TestSUB(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		h := NewMachine()
+		h := New()
 		h.ac = c.ac
 		h.mem[h.pc] = c.inst
 		h.Step()
@@ -441,7 +517,7 @@ func TestMUL(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		h := NewMachine()
+		h := New()
 		h.mq = c.mq
 		h.mem[h.pc] = c.inst
 		h.mem[1] = c.addr1
@@ -469,13 +545,13 @@ func TestDIV(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		h := NewMachine()
+		h := New()
 		h.mq = c.mq
 		h.mem[h.pc] = c.inst
 		h.mem[1] = c.addr1
 		h.Step()
 		if h.state != c.state {
-			t.Errorf("%02d: Got cpustate = %q; Wanted %q", h.state, c.state)
+			t.Errorf("%02d: Got cpustate = %q; Wanted %q", i, h.state, c.state)
 		}
 
 		if h.mq != c.wantMQ || h.ac != c.wantAC {
@@ -488,11 +564,12 @@ func TestGet(t *testing.T) {
 	cases := []struct {
 		input int
 		want  int
+		state CPUState
 	}{
-		{42, 42},
-		{-1000000, -99999},
-		{99999, 99999},
-		{-3, -3},
+		{42, 42, CPUok},
+		{-1000000, 30000, CPUio}, // Out of range, leaving the GET in place
+		{99999, 99999, CPUok},
+		{-3, -3, CPUok},
 	}
 
 	orig := Input // Store a reference to original Getter
@@ -501,12 +578,12 @@ func TestGet(t *testing.T) {
 	}()
 
 	for i, c := range cases {
-		Input = func() int { return c.input }
-		h := NewMachine()
+		Input = func() (int, error) { return c.input, nil }
+		h := New()
 		h.mem[h.pc] = 30000 // Read to address 0
 		h.Step()
-		if h.mem[0] != c.want {
-			t.Errorf("%02d: GET = %05d; Wanted %05d", i, h.mem[0], c)
+		if h.mem[0] != c.want || h.state != c.state {
+			t.Errorf("%02d: GET = %05d, %s; Wanted %05d, %s", i, h.mem[0], h.state, c.want, c.state)
 		}
 	}
 }
@@ -528,7 +605,7 @@ func TestPut(t *testing.T) {
 	for i, c := range cases {
 		var got int
 		Output = func(i int) { got = i }
-		h := NewMachine()
+		h := New()
 		h.mem[0] = c.inst
 		h.Step()
 		if got != c.want {
@@ -536,3 +613,141 @@ func TestPut(t *testing.T) {
 		}
 	}
 }
+
+func TestRunContext(t *testing.T) {
+	cases := []struct {
+		prog     string
+		maxSteps int
+		want     StopReason
+		state    CPUState
+	}{
+		{"0: 00000", 0, StopHalted, CPUhalt},
+		{"0: 32000", 0, StopFaulted, CPUbadinst},
+		{"0: 05050", 0, StopFaulted, CPUbadaddr},
+		{"0: 05000", 100, StopBudget, CPUok},
+		{"0: 05001\n1: 00000", 2, StopHalted, CPUhalt},
+		{"0: 05001\n1: 00000", 1, StopBudget, CPUok},
+	}
+
+	for i, c := range cases {
+		h := New()
+		if err := h.LoadProgram(strings.NewReader(c.prog)); err != nil {
+			t.Fatalf("%02d: h.LoadProgram(%q) = %v", i, c.prog, err)
+		}
+		got, err := h.RunContext(context.Background(), c.maxSteps)
+		if got != c.want || err != nil || h.state != c.state {
+			t.Errorf("%02d: h.RunContext(%q, %d) = (%s, %v), state %s; want (%s, nil), state %s", i, c.prog, c.maxSteps, got, err, h.state, c.want, c.state)
+		}
+	}
+}
+
+func TestRunContextCancel(t *testing.T) {
+	h := New()
+	h.mem[0] = 5000 // JMP 0, forever
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan StopReason)
+	go func() {
+		r, err := h.RunContext(ctx, 0)
+		if err != context.Canceled {
+			t.Errorf("h.RunContext() error = %v; want %v", err, context.Canceled)
+		}
+		done <- r
+	}()
+
+	cancel()
+	select {
+	case r := <-done:
+		if r != StopCancelled {
+			t.Errorf("h.RunContext() = %s; want %s", r, StopCancelled)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("h.RunContext() didn't return after cancellation")
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	var got int
+	h := New(WithInput(func() (int, error) { return 42, nil }), WithOutput(func(i int) { got = i }))
+	if err := h.LoadProgram(strings.NewReader("0: 30010\n1: 31010")); err != nil {
+		t.Fatalf("h.LoadProgram() = %v; want nil", err)
+	}
+
+	h.Run()
+	if got != 42 {
+		t.Errorf("Output = %d; want 42", got)
+	}
+}
+
+func TestAccessors(t *testing.T) {
+	h := New()
+	h.pc, h.ac, h.mq, h.state = 3, -4, 5, CPUdivzero
+	h.mem[7] = 31007
+	h.ToggleTrace()
+
+	if h.PC() != 3 || h.AC() != -4 || h.MQ() != 5 || h.State() != CPUdivzero || !h.Tracing() {
+		t.Errorf("(PC, AC, MQ, State, Tracing) = (%d, %d, %d, %s, %t); want (3, -4, 5, CPUdivzero, true)", h.PC(), h.AC(), h.MQ(), h.State(), h.Tracing())
+	}
+
+	m := h.Memory()
+	if m != h.mem {
+		t.Errorf("h.Memory() = %v; want %v", m, h.mem)
+	}
+	m[7] = 0
+	if h.mem[7] != 31007 {
+		t.Errorf("Changing the result of h.Memory() changed the machine")
+	}
+}
+
+func TestLoadError(t *testing.T) {
+	cases := []struct {
+		prog string
+		want LoadError
+	}{
+		{"0: 0\n: 31000 // Bad line", LoadError{2, ": 31000 // Bad line", ": 31000 // Bad line", ErrLoadBadLine}},
+		{"0: 0\n1: 0\n50: 31000", LoadError{3, "50", "50: 31000", ErrLoadBadAddr}},
+		{"1: 99999999999999999999 // Too big", LoadError{1, "99999999999999999999", "1: 99999999999999999999 // Too big", ErrLoadBadValue}},
+	}
+
+	for i, c := range cases {
+		h := New()
+		err := h.LoadProgram(strings.NewReader(c.prog))
+		var le *LoadError
+		if !errors.As(err, &le) {
+			t.Errorf("%02d: h.LoadProgram(%q) = %v; want a *LoadError", i, c.prog, err)
+			continue
+		}
+		if *le != c.want {
+			t.Errorf("%02d: h.LoadProgram(%q) = %+v; want %+v", i, c.prog, *le, c.want)
+		}
+	}
+}
+
+func TestConsole(t *testing.T) {
+	var b bytes.Buffer
+	h := New(WithConsole(&b))
+	if err := h.LoadProgram(strings.NewReader("0: 31001")); err != nil {
+		t.Fatalf("h.LoadProgram() = %v; want nil", err)
+	}
+	h.ToggleTrace()
+	h.Run()
+	h.DumpRegs()
+	h.stack = []int{3, 12}
+	h.DumpStack()
+	h.ResetCPU()
+	h.DumpStack()
+
+	want := `Program loaded successfully.
+Tracing mode: true
+PUT 001
+HLT 000
+Program terminated with: "CPUhalt"
+PC: 02  AC:  00000  MQ:  00000
+03 12
+CPU state reset.
+(empty)
+`
+	if got := b.String(); got != want {
+		t.Errorf("Console output = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,162 @@
+// This file contains the export of execution profiles in the pprof
+// format, so they can be read by go tool pprof. The format is a
+// gzipped protocol buffer; see profile.proto in the pprof sources.
+// Only the handful of fields hypo needs are encoded here.
+
+package machine
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// Field numbers from profile.proto.
+const (
+	pprofSampleType  = 1  // Profile.sample_type
+	pprofSample      = 2  // Profile.sample
+	pprofLocation    = 4  // Profile.location
+	pprofFunction    = 5  // Profile.function
+	pprofStringTable = 6  // Profile.string_table
+	pprofPeriodType  = 11 // Profile.period_type
+	pprofPeriod      = 12 // Profile.period
+
+	pprofValueTypeType = 1 // ValueType.type
+	pprofValueTypeUnit = 2 // ValueType.unit
+
+	pprofSampleLocation = 1 // Sample.location_id
+	pprofSampleValue    = 2 // Sample.value
+
+	pprofLocationID      = 1 // Location.id
+	pprofLocationAddress = 3 // Location.address
+	pprofLocationLine    = 4 // Location.line
+
+	pprofLineFunction = 1 // Line.function_id
+	pprofLineLine     = 2 // Line.line
+
+	pprofFunctionID   = 1 // Function.id
+	pprofFunctionName = 2 // Function.name
+)
+
+// Protocol buffer wire types.
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// protoBuf accumulates an encoded protocol buffer message.
+type protoBuf struct {
+	b []byte
+}
+
+func (p *protoBuf) varint(x uint64) {
+	for x >= 0x80 {
+		p.b = append(p.b, byte(x)|0x80)
+		x >>= 7
+	}
+	p.b = append(p.b, byte(x))
+}
+
+func (p *protoBuf) tag(field, wire int) {
+	p.varint(uint64(field)<<3 | uint64(wire))
+}
+
+// int64 encodes a non-repeated integer field. Zero is the default
+// value, so it is omitted.
+func (p *protoBuf) int64(field int, x int64) {
+	if x == 0 {
+		return
+	}
+	p.tag(field, wireVarint)
+	p.varint(uint64(x))
+}
+
+// packed encodes a repeated integer field.
+func (p *protoBuf) packed(field int, xs ...int64) {
+	var m protoBuf
+	for _, x := range xs {
+		m.varint(uint64(x))
+	}
+	p.bytes(field, m.b)
+}
+
+func (p *protoBuf) bytes(field int, b []byte) {
+	p.tag(field, wireBytes)
+	p.varint(uint64(len(b)))
+	p.b = append(p.b, b...)
+}
+
+func (p *protoBuf) message(field int, m *protoBuf) {
+	p.bytes(field, m.b)
+}
+
+// WritePprof writes the profile to w in the gzipped protocol buffer
+// format read by go tool pprof. Each executed address is a frame,
+// named after the closest preceding assembler label where the program
+// has labels. Lines refer to the program source where known.
+func (p *Profile) WritePprof(w io.Writer) error {
+	strs := []string{""}
+	index := map[string]int64{"": 0}
+	str := func(s string) int64 {
+		if i, ok := index[s]; ok {
+			return i
+		}
+		strs = append(strs, s)
+		index[s] = int64(len(strs) - 1)
+		return index[s]
+	}
+
+	var pb protoBuf
+	var vt protoBuf
+	vt.int64(pprofValueTypeType, str("instructions"))
+	vt.int64(pprofValueTypeUnit, str("count"))
+	pb.message(pprofSampleType, &vt)
+	pb.message(pprofPeriodType, &vt)
+	pb.int64(pprofPeriod, 1)
+
+	funcs := map[string]int64{}
+	for a, n := range p.count {
+		if n == 0 {
+			continue
+		}
+		// Ids must be non-zero, so offset addresses by one.
+		id := int64(a + 1)
+
+		var s protoBuf
+		s.packed(pprofSampleLocation, id)
+		s.packed(pprofSampleValue, int64(n))
+		pb.message(pprofSample, &s)
+
+		name := p.prog.symbol(a)
+		fid, ok := funcs[name]
+		if !ok {
+			fid = int64(len(funcs) + 1)
+			funcs[name] = fid
+			var f protoBuf
+			f.int64(pprofFunctionID, fid)
+			f.int64(pprofFunctionName, str(name))
+			pb.message(pprofFunction, &f)
+		}
+
+		line := int64(p.prog.lines[a])
+		if line == 0 {
+			line = int64(a)
+		}
+		var l, loc protoBuf
+		l.int64(pprofLineFunction, fid)
+		l.int64(pprofLineLine, line)
+		loc.int64(pprofLocationID, id)
+		loc.int64(pprofLocationAddress, int64(a))
+		loc.message(pprofLocationLine, &l)
+		pb.message(pprofLocation, &loc)
+	}
+
+	for _, s := range strs {
+		pb.bytes(pprofStringTable, []byte(s))
+	}
+
+	z := gzip.NewWriter(w)
+	if _, err := z.Write(pb.b); err != nil {
+		return err
+	}
+	return z.Close()
+}
@@ -0,0 +1,130 @@
+// This file contains the execution profiler.
+
+package machine
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// conditional reports whether op is a jump that depends on the
+// accumulator.
+func conditional(op string) bool {
+	switch op {
+	case "JEQ", "JGT", "JLT", "JLE", "JNE":
+		return true
+	}
+	return false
+}
+
+// Profile counts the instructions a machine executes, by address and
+// by opcode, along with which way each conditional jump went.
+type Profile struct {
+	prog     *Program             // Source information used to name addresses
+	total    int                  // Instructions executed
+	count    [MemSize]int         // Executions per address
+	inst     [MemSize]Instruction // The instruction most recently executed at each address
+	ops      map[string]int       // Executions per opcode
+	taken    [MemSize]int         // Conditional jumps taken, per address
+	notTaken [MemSize]int         // Conditional jumps not taken, per address
+}
+
+// add records the execution of instruction i at addr, with the
+// accumulator holding ac beforehand.
+func (p *Profile) add(addr int, i Instruction, ac int) {
+	p.total++
+	p.count[addr]++
+	p.inst[addr] = i
+	p.ops[i.op]++
+	if conditional(i.op) {
+		if jumpTaken(i.op, ac) {
+			p.taken[addr]++
+		} else {
+			p.notTaken[addr]++
+		}
+	}
+}
+
+// StartProfile starts collecting a new profile of the instructions
+// the machine executes, discarding any profile already in progress.
+// The returned profile is updated as the machine runs.
+func (h *Machine) StartProfile() *Profile {
+	h.prof = &Profile{prog: h.program(), ops: map[string]int{}}
+	return h.prof
+}
+
+// StopProfile stops profiling and returns the completed profile, or
+// nil if the machine wasn't being profiled.
+func (h *Machine) StopProfile() *Profile {
+	p := h.prof
+	h.prof = nil
+	return p
+}
+
+// Total returns the number of instructions executed.
+func (p *Profile) Total() int {
+	return p.total
+}
+
+// Count returns the number of times the instruction at addr was
+// executed.
+func (p *Profile) Count(addr int) int {
+	if !inBounds(addr) {
+		return 0
+	}
+	return p.count[addr]
+}
+
+// OpCount returns the number of times instructions with the mnemonic
+// op were executed.
+func (p *Profile) OpCount(op string) int {
+	return p.ops[op]
+}
+
+// Branches returns the number of times the conditional jump at addr
+// was taken and not taken.
+func (p *Profile) Branches(addr int) (taken, notTaken int) {
+	if !inBounds(addr) {
+		return 0, 0
+	}
+	return p.taken[addr], p.notTaken[addr]
+}
+
+// Report writes a readable summary of the profile to w.
+func (p *Profile) Report(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "Instructions executed: %d\n\n", p.total); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Addr     Count  Instruction  Taken  Not taken  Symbol\n")
+	for a, n := range p.count {
+		if n == 0 {
+			continue
+		}
+		branches := fmt.Sprintf("%5s  %9s", "", "")
+		if conditional(p.inst[a].op) {
+			branches = fmt.Sprintf("%5d  %9d", p.taken[a], p.notTaken[a])
+		}
+		fmt.Fprintf(w, "  %02d  %8d  %-11s  %s  %s\n", a, n, p.inst[a], branches, p.prog.symbol(a))
+	}
+
+	ops := make([]string, 0, len(p.ops))
+	for o := range p.ops {
+		ops = append(ops, o)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if p.ops[ops[i]] != p.ops[ops[j]] {
+			return p.ops[ops[i]] > p.ops[ops[j]]
+		}
+		return ops[i] < ops[j]
+	})
+
+	fmt.Fprintf(w, "\nOp       Count\n")
+	for _, o := range ops {
+		if _, err := fmt.Fprintf(w, "%s  %8d\n", o, p.ops[o]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package machine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	h, _ := newLoopMachine(t)
+	p := h.StartProfile()
+	h.Run()
+
+	if got := h.StopProfile(); got != p {
+		t.Fatalf("h.StopProfile() = %p; want %p", got, p)
+	}
+	if h.StopProfile() != nil {
+		t.Errorf("h.StopProfile() when not profiling != nil")
+	}
+
+	// The loop body runs 3 times, followed by one HLT.
+	if p.Total() != 3*6+1 {
+		t.Errorf("p.Total() = %d; want %d", p.Total(), 3*6+1)
+	}
+	for a := 0; a < 6; a++ {
+		if p.Count(a) != 3 {
+			t.Errorf("p.Count(%d) = %d; want 3", a, p.Count(a))
+		}
+	}
+	if p.Count(6) != 1 || p.Count(7) != 0 || p.Count(MemSize) != 0 {
+		t.Errorf("p.Count(6, 7, MemSize) = (%d, %d, %d); want (1, 0, 0)", p.Count(6), p.Count(7), p.Count(MemSize))
+	}
+
+	ops := map[string]int{"LAC": 3, "ADD": 3, "PAC": 3, "PUT": 3, "SUB": 3, "JLT": 3, "HLT": 1, "JMP": 0}
+	for o, want := range ops {
+		if got := p.OpCount(o); got != want {
+			t.Errorf("p.OpCount(%q) = %d; want %d", o, got, want)
+		}
+	}
+
+	if taken, notTaken := p.Branches(5); taken != 2 || notTaken != 1 {
+		t.Errorf("p.Branches(5) = (%d, %d); want (2, 1)", taken, notTaken)
+	}
+	if taken, notTaken := p.Branches(0); taken != 0 || notTaken != 0 {
+		t.Errorf("p.Branches(0) = (%d, %d); want (0, 0)", taken, notTaken)
+	}
+
+	var b bytes.Buffer
+	if err := p.Report(&b); err != nil {
+		t.Fatalf("p.Report() = %v; want nil", err)
+	}
+	for _, want := range []string{"Instructions executed: 19", "  05         3  JLT 000          2          1  addr05"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("p.Report() = %q; want it to contain %q", b.String(), want)
+		}
+	}
+}
+
+func TestSymbol(t *testing.T) {
+	p, err := Assemble(strings.NewReader("HLT\nloop: HLT\nHLT\nORG 10\nb:\na: HLT"))
+	if err != nil {
+		t.Fatalf("Assemble() = %v; want nil", err)
+	}
+
+	cases := []struct {
+		addr int
+		want string
+	}{
+		{0, "addr00"},
+		{1, "loop"},
+		{2, "loop+1"},
+		{10, "a"}, // Ties go to the alphabetically first label
+		{11, "a+1"},
+	}
+	for i, c := range cases {
+		if got := p.symbol(c.addr); got != c.want {
+			t.Errorf("%02d: p.symbol(%d) = %q; want %q", i, c.addr, got, c.want)
+		}
+	}
+}
+
+// protoFields decodes the top level fields of a protocol buffer
+// message into a map from field number to the raw values seen for it.
+// Varints are returned as their value and length delimited fields as
+// their contents.
+func protoFields(t *testing.T, b []byte) map[int][]interface{} {
+	t.Helper()
+	varint := func() uint64 {
+		var x uint64
+		for s := uint(0); ; s += 7 {
+			if len(b) == 0 {
+				t.Fatal("Truncated varint")
+			}
+			c := b[0]
+			b = b[1:]
+			x |= uint64(c&0x7f) << s
+			if c < 0x80 {
+				return x
+			}
+		}
+	}
+
+	f := map[int][]interface{}{}
+	for len(b) > 0 {
+		tag := varint()
+		switch tag & 7 {
+		case wireVarint:
+			f[int(tag>>3)] = append(f[int(tag>>3)], varint())
+		case wireBytes:
+			n := varint()
+			f[int(tag>>3)] = append(f[int(tag>>3)], b[:n])
+			b = b[n:]
+		default:
+			t.Fatalf("Unexpected wire type %d", tag&7)
+		}
+	}
+	return f
+}
+
+func TestWritePprof(t *testing.T) {
+	src := "start: GET x\nLAC x\nloop: SUB one\nJGT loop\nHLT\nx: DAT 0\none: DAT 1"
+	p, err := Assemble(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("Assemble() = %v; want nil", err)
+	}
+
+	h := New(WithInput(func() (int, error) { return 2, nil }))
+	h.Load(p)
+	prof := h.StartProfile()
+	h.Run()
+
+	var b bytes.Buffer
+	if err := prof.WritePprof(&b); err != nil {
+		t.Fatalf("prof.WritePprof() = %v; want nil", err)
+	}
+
+	z, err := gzip.NewReader(&b)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() = %v; want nil", err)
+	}
+	raw, err := io.ReadAll(z)
+	if err != nil {
+		t.Fatalf("Reading profile = %v; want nil", err)
+	}
+
+	f := protoFields(t, raw)
+	var strs []string
+	for _, s := range f[pprofStringTable] {
+		strs = append(strs, string(s.([]byte)))
+	}
+
+	// Every executed address is a sample and a location; each label
+	// or label offset is a function.
+	if len(f[pprofSample]) != 5 || len(f[pprofLocation]) != 5 || len(f[pprofFunction]) != 5 {
+		t.Errorf("Got %d samples, %d locations, %d functions; want 5 of each", len(f[pprofSample]), len(f[pprofLocation]), len(f[pprofFunction]))
+	}
+	for _, want := range []string{"", "instructions", "count", "start", "start+1", "loop", "loop+1", "loop+2"} {
+		found := false
+		for _, s := range strs {
+			found = found || s == want
+		}
+		if !found {
+			t.Errorf("String table %q is missing %q", strs, want)
+		}
+	}
+
+	// The SUB at loop runs twice, so its sample holds location 3 and
+	// value 2.
+	s := protoFields(t, f[pprofSample][2].([]byte))
+	loc, val := s[pprofSampleLocation][0].([]byte), s[pprofSampleValue][0].([]byte)
+	if !bytes.Equal(loc, []byte{3}) || !bytes.Equal(val, []byte{2}) {
+		t.Errorf("Sample for loop = (location %v, value %v); want ([3], [2])", loc, val)
+	}
+}
@@ -0,0 +1,147 @@
+// This file contains the representation of hypo programs as they
+// appear on disk, before they are loaded into a machine.
+
+package machine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Code files are instructions with optional, free-form comments
+// following them
+var progLineRE = regexp.MustCompile("^(\\d+):\\s*(-*\\d+)(\\s.*)*$")
+
+// Program is a memory image along with the source information that
+// produced it. Loading a program into a machine only needs the memory
+// image, but tools that report on programs use the rest.
+type Program struct {
+	mem      [MemSize]int    // The memory image
+	used     [MemSize]bool   // Whether the source set each address
+	comments [MemSize]string // Trailing comment from the source line for each address
+	lines    [MemSize]int    // Source line number that set each address
+	labels   map[string]int  // Label names and the address each refers to, if known
+
+	overwritten []overwrite // Source entries replaced by later ones for the same address
+}
+
+// overwrite records a source entry for an address that a later entry
+// replaced.
+type overwrite struct {
+	addr  int // The address both entries set
+	line  int // The line of the replaced entry
+	value int // The value the replaced entry set
+	by    int // The line of the replacing entry
+}
+
+// LoadError describes why a program couldn't be loaded. Err is one of
+// the ErrLoad values, so errors.Is can be used to tell them apart.
+type LoadError struct {
+	Line    int    // The line number the error was found on
+	Text    string // The offending text
+	Content string // The complete line
+	Err     error
+}
+
+func (e *LoadError) Error() string {
+	if e.Content == "" {
+		return fmt.Sprintf("line %d: %v: %s", e.Line, e.Err, e.Text)
+	}
+	return fmt.Sprintf("line %d: %v: %q in %q", e.Line, e.Err, e.Text, e.Content)
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// ParseProgram reads a program in the addr: value format. Where an
+// address is set more than once, the final entry (and its comment)
+// takes precedence. The returned error is a *LoadError.
+func ParseProgram(r io.Reader) (*Program, error) {
+	p := &Program{}
+
+	n := 0
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		n++
+		line := s.Text()
+		m := progLineRE.FindStringSubmatch(line)
+		if m == nil {
+			return nil, &LoadError{n, line, line, ErrLoadBadLine}
+		}
+
+		a, err := strconv.Atoi(m[1]) // The address for this instruction to be stored
+		if err != nil || a < 0 || a >= MemSize {
+			return nil, &LoadError{n, m[1], line, ErrLoadBadAddr}
+		}
+
+		v, err := strconv.Atoi(m[2])
+		if err != nil {
+			return nil, &LoadError{n, m[2], line, ErrLoadBadValue}
+		}
+		if p.used[a] {
+			p.overwritten = append(p.overwritten, overwrite{a, p.lines[a], p.mem[a], n})
+		}
+		p.mem[a] = boundsCap(v)
+		p.used[a] = true
+		p.comments[a] = comment(m[3])
+		p.lines[a] = n
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, &LoadError{n + 1, err.Error(), "", ErrLoadBadFile}
+	}
+
+	return p, nil
+}
+
+// comment strips the whitespace and leading // from the free-form
+// text following a value.
+func comment(s string) string {
+	return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), "//"))
+}
+
+// WriteTo writes the program to w in the format accepted by
+// Machine.LoadProgram, keeping source comments.
+func (p *Program) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for i, v := range p.mem {
+		if !p.used[i] {
+			continue
+		}
+		line := fmt.Sprintf("%02d: %05d", i, v)
+		if p.comments[i] != "" {
+			line += " // " + p.comments[i]
+		}
+		n, err := fmt.Fprintln(w, line)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// symbol names addr after the closest label at or before it, such as
+// loop or loop+2. Addresses with no such label are named by number.
+func (p *Program) symbol(addr int) string {
+	best, at := "", -1
+	for l, a := range p.labels {
+		if a <= addr && (a > at || (a == at && l < best)) {
+			best, at = l, a
+		}
+	}
+
+	switch {
+	case at < 0:
+		return fmt.Sprintf("addr%02d", addr)
+	case at == addr:
+		return best
+	default:
+		return fmt.Sprintf("%s+%d", best, addr-at)
+	}
+}
@@ -0,0 +1,32 @@
+package machine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseProgramComments(t *testing.T) {
+	prog := "0: 0 // Comment line\n0: 31002 // Print it\n1: 05000\n2: 99103    //   Value  "
+	cases := []struct {
+		addr    int
+		used    bool
+		comment string
+		line    int
+	}{
+		{0, true, "Print it", 2}, // The final entry for an address wins
+		{1, true, "", 3},
+		{2, true, "Value", 4},
+		{3, false, "", 0},
+	}
+
+	p, err := ParseProgram(strings.NewReader(prog))
+	if err != nil {
+		t.Fatalf("ParseProgram() = %v; want nil", err)
+	}
+
+	for i, c := range cases {
+		if p.used[c.addr] != c.used || p.comments[c.addr] != c.comment || p.lines[c.addr] != c.line {
+			t.Errorf("%02d: addr %d = (%t, %q, %d); want (%t, %q, %d)", i, c.addr, p.used[c.addr], p.comments[c.addr], p.lines[c.addr], c.used, c.comment, c.line)
+		}
+	}
+}
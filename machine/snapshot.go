@@ -0,0 +1,185 @@
+// This file contains support for saving and restoring complete
+// machine snapshots.
+
+package machine
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// Snapshot format versions. Each adds fields to the one before, so
+// LoadSnapshot still accepts older snapshots, which leave the newer
+// state at its reset value. A new version must be added whenever
+// fields are.
+const (
+	snapOriginal = iota + 1 // Memory, registers, CPU state and trace flag
+	snapISA                 // The instruction set
+	snapStack               // The return stack
+	snapTraps               // Trap handlers, saved PC, pending interrupts and timer
+	snapChannel             // The selected I/O channel
+
+	snapVersion = snapChannel // The version SaveSnapshot writes
+)
+
+// Errors returned by LoadSnapshot.
+var (
+	ErrSnapBadFile = errors.New("Invalid snapshot file")
+	ErrSnapVersion = errors.New("Unsupported snapshot version")
+	ErrSnapBadData = errors.New("Invalid machine state in snapshot")
+)
+
+// snapshot is the on-disk form of a machine's state.
+type snapshot struct {
+	Version int    `json:"version"`
+	PC      int    `json:"pc"`
+	AC      int    `json:"ac"`
+	MQ      int    `json:"mq"`
+	State   string `json:"state"`
+	Trace   bool   `json:"trace"`
+	ISA     string `json:"isa,omitempty"`     // Empty for the standard ISA
+	Stack   []int  `json:"stack,omitempty"`   // Return addresses, innermost last
+	Channel int    `json:"channel,omitempty"` // The selected I/O channel
+
+	// Trap state, all omitted if the program doesn't use traps.
+	Traps    map[string]int `json:"traps,omitempty"` // Handler address by trap name
+	EPC      int            `json:"epc,omitempty"`
+	Handling bool           `json:"handling,omitempty"`
+	Pending  []string       `json:"pending,omitempty"` // Interrupts waiting to be taken
+	Timer    int            `json:"timer,omitempty"`
+	Ticks    int            `json:"ticks,omitempty"`
+
+	Mem []int `json:"mem"`
+}
+
+// minVersion returns the oldest format version with every field s
+// sets.
+func (s *snapshot) minVersion() int {
+	switch {
+	case s.Channel != 0:
+		return snapChannel
+	case s.Traps != nil || s.EPC != 0 || s.Handling || s.Pending != nil || s.Timer != 0 || s.Ticks != 0:
+		return snapTraps
+	case len(s.Stack) > 0:
+		return snapStack
+	case s.ISA != "":
+		return snapISA
+	}
+	return snapOriginal
+}
+
+// SaveSnapshot writes the machine's memory, registers, return stack,
+// trap state, I/O channel, CPU state, trace flag and instruction set
+// to w.
+func (h *Machine) SaveSnapshot(w io.Writer) error {
+	s := snapshot{
+		Version: snapVersion,
+		PC:      h.pc,
+		AC:      h.ac,
+		MQ:      h.mq,
+		State:   h.state.String(),
+		Trace:   h.trace,
+		Stack:   h.stack,
+		Channel: h.ch,
+		Mem:     h.mem[:],
+
+		EPC:      h.traps.epc,
+		Handling: h.traps.handling,
+		Timer:    h.timer,
+		Ticks:    h.traps.ticks,
+	}
+	if h.isa != ISAStandard {
+		s.ISA = h.isa.String()
+	}
+	for t := TrapBadInst; t < numTraps; t++ {
+		if a, ok := h.TrapHandler(t); ok {
+			if s.Traps == nil {
+				s.Traps = map[string]int{}
+			}
+			s.Traps[t.String()] = a
+		}
+		if h.traps.pending&(1<<uint(t)) != 0 {
+			s.Pending = append(s.Pending, t.String())
+		}
+	}
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(s)
+}
+
+// LoadSnapshot replaces the machine's memory, registers, return stack,
+// trap state, I/O channel, CPU state, trace flag and instruction set
+// with a snapshot written by SaveSnapshot. The machine is unchanged if
+// the snapshot can't be loaded. Execution history is discarded, as it
+// doesn't lead to the restored state. The error is one of the ErrSnap
+// values.
+func (h *Machine) LoadSnapshot(r io.Reader) error {
+	var s snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return ErrSnapBadFile
+	}
+
+	if s.Version < snapOriginal || s.Version > snapVersion {
+		return ErrSnapVersion
+	}
+
+	cs, ok := ParseCPUState(s.State)
+	if !ok || s.minVersion() > s.Version || len(s.Mem) != MemSize || len(s.Stack) > StackSize || !validChannel(s.Channel) {
+		return ErrSnapBadData
+	}
+	// A return address may be just past the end of memory, after a CAL
+	// in the last cell.
+	for _, a := range s.Stack {
+		if a < 0 || a > MemSize {
+			return ErrSnapBadData
+		}
+	}
+	isa := ISAStandard
+	if s.ISA != "" {
+		if isa, ok = ParseISA(s.ISA); !ok {
+			return ErrSnapBadData
+		}
+	}
+	for _, v := range append([]int{s.AC, s.MQ, s.EPC}, s.Mem...) {
+		if v != boundsCap(v) {
+			return ErrSnapBadData
+		}
+	}
+
+	var traps trapState
+	for name, a := range s.Traps {
+		t, ok := ParseTrap(name)
+		if !ok || !inBounds(a) {
+			return ErrSnapBadData
+		}
+		traps.vec[t] = a
+		traps.set |= 1 << uint(t)
+	}
+	for _, name := range s.Pending {
+		t, ok := ParseTrap(name)
+		if !ok {
+			return ErrSnapBadData
+		}
+		traps.pending |= 1 << uint(t)
+	}
+	if s.Timer < 0 || s.Ticks < 0 {
+		return ErrSnapBadData
+	}
+	traps.epc, traps.handling, traps.ticks = s.EPC, s.Handling, s.Ticks
+
+	copy(h.mem[:], s.Mem)
+	h.isa = isa
+	h.flushCells()
+	h.pc, h.ac, h.mq = s.PC, s.AC, s.MQ
+	h.stack = append([]int(nil), s.Stack...)
+	h.ch = s.Channel
+	h.traps, h.timer = traps, s.Timer
+	h.state = cs
+	h.trace = s.Trace
+	h.prog = nil
+	h.hit = nil
+	h.hist.clear()
+	return nil
+}
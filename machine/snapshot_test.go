@@ -0,0 +1,110 @@
+package machine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	h, _ := newLoopMachine(t)
+	h.SetBreakpoint(3)
+	h.Run()
+	h.ToggleTrace()
+
+	var b bytes.Buffer
+	if err := h.SaveSnapshot(&b); err != nil {
+		t.Fatalf("h.SaveSnapshot() = %v; want nil", err)
+	}
+
+	g := New()
+	if err := g.LoadSnapshot(&b); err != nil {
+		t.Fatalf("g.LoadSnapshot() = %v; want nil", err)
+	}
+
+	if stateOf(g) != stateOf(h) || g.trace != h.trace {
+		t.Errorf("Restored state = %+v (trace %t); want %+v (trace %t)", stateOf(g), g.trace, stateOf(h), h.trace)
+	}
+	if g.StepBack() {
+		t.Errorf("g.StepBack() after restore = true; want false")
+	}
+
+	// Both machines should now finish identically.
+	var out []int
+	g.chans[0].out = func(i int) { out = append(out, i) }
+	g.Run()
+	if g.state != CPUhalt || len(out) != 3 {
+		t.Errorf("After restore, state = %s, output = %v; want CPUhalt with 3 outputs", g.state, out)
+	}
+}
+
+func TestSnapshotStack(t *testing.T) {
+	h := New()
+	if err := h.LoadProgram(strings.NewReader("0: 08010\n10: 08049\n49: 0")); err != nil {
+		t.Fatal(err)
+	}
+	h.Step()
+	h.Step()
+
+	var b bytes.Buffer
+	if err := h.SaveSnapshot(&b); err != nil {
+		t.Fatal(err)
+	}
+	g := New()
+	if err := g.LoadSnapshot(&b); err != nil {
+		t.Fatalf("g.LoadSnapshot() = %v; want nil", err)
+	}
+	if stateOf(g) != stateOf(h) {
+		t.Errorf("Restored state = %+v; want %+v", stateOf(g), stateOf(h))
+	}
+
+	// A snapshot without a stack empties it.
+	b.Reset()
+	New().SaveSnapshot(&b)
+	if strings.Contains(b.String(), "stack") {
+		t.Errorf("SaveSnapshot() = %s; want no stack", b.String())
+	}
+	if err := g.LoadSnapshot(&b); err != nil || len(g.stack) != 0 {
+		t.Errorf("g.LoadSnapshot() = %v with stack %v; want nil with an empty stack", err, g.stack)
+	}
+}
+
+func TestLoadSnapshotErrors(t *testing.T) {
+	mem := "[" + strings.Repeat("0, ", MemSize-1) + "0]"
+	cases := []struct {
+		snap string
+		want error
+	}{
+		{"", ErrSnapBadFile},
+		{"not json", ErrSnapBadFile},
+		{`{"version": 0, "state": "CPUok", "mem": ` + mem + `}`, ErrSnapVersion},
+		{`{"version": 6, "state": "CPUok", "mem": ` + mem + `}`, ErrSnapVersion},
+		{`{"version": 1, "state": "CPUbogus", "mem": ` + mem + `}`, ErrSnapBadData},
+		{`{"version": 1, "state": "CPUok", "mem": [0, 1]}`, ErrSnapBadData},
+		{`{"version": 1, "state": "CPUok", "ac": 100000, "mem": ` + mem + `}`, ErrSnapBadData},
+		{`{"version": 3, "state": "CPUok", "stack": [51], "mem": ` + mem + `}`, ErrSnapBadData},
+		{`{"version": 3, "state": "CPUok", "stack": [1, 2, 3, 4, 5, 6, 7, 8, 9], "mem": ` + mem + `}`, ErrSnapBadData},
+		// Older versions can't set fields added after them.
+		{`{"version": 1, "state": "CPUok", "isa": "extended", "mem": ` + mem + `}`, ErrSnapBadData},
+		{`{"version": 2, "state": "CPUok", "stack": [1], "mem": ` + mem + `}`, ErrSnapBadData},
+		{`{"version": 3, "state": "CPUok", "timer": 5, "mem": ` + mem + `}`, ErrSnapBadData},
+		{`{"version": 4, "state": "CPUok", "channel": 2, "mem": ` + mem + `}`, ErrSnapBadData},
+		{`{"version": 1, "state": "CPUhalt", "pc": 7, "mem": ` + mem + `}`, nil},
+		{`{"version": 5, "state": "CPUhalt", "pc": 7, "mem": ` + mem + `}`, nil},
+	}
+
+	for i, c := range cases {
+		h := New()
+		h.mem[0] = 31000
+		err := h.LoadSnapshot(strings.NewReader(c.snap))
+		if err != c.want {
+			t.Errorf("%02d: h.LoadSnapshot(%q) = %v; want %v", i, c.snap, err, c.want)
+		}
+		if err != nil && (h.mem[0] != 31000 || h.state != CPUok) {
+			t.Errorf("%02d: failed h.LoadSnapshot() changed the machine", i)
+		}
+		if err == nil && (h.mem[0] != 0 || h.pc != 7 || h.state != CPUhalt) {
+			t.Errorf("%02d: h.LoadSnapshot() state = %+v; want pc 7, CPUhalt, empty memory", i, stateOf(h))
+		}
+	}
+}
@@ -0,0 +1,717 @@
+// This file contains the symbolic execution engine. It explores the
+// paths through a program with the values read by GET treated as
+// unknowns, looking for inputs that drive execution down each one.
+
+package machine
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Limits used by Explore when none are given.
+const (
+	exploreSteps = 1000 // Instructions executed along a single path
+	explorePaths = 100  // Paths explored in total
+)
+
+// relation is a comparison of a value against zero.
+type relation int
+
+const (
+	relEQ relation = iota
+	relNE
+	relGT
+	relLE
+	relLT
+	relGE
+)
+
+func (r relation) String() string {
+	return [...]string{"==", "!=", ">", "<=", "<", ">="}[r]
+}
+
+// branchRelations holds the comparison of AC against zero under which
+// each conditional jump is taken, followed by the one under which it
+// falls through.
+var branchRelations = map[string][2]relation{
+	"JEQ": {relEQ, relNE},
+	"JGT": {relGT, relLE},
+	"JLT": {relLT, relGE},
+	"JLE": {relLE, relGT},
+	"JNE": {relNE, relEQ},
+}
+
+// distance returns how far v is from satisfying r: zero if it does,
+// and larger the more it would have to change to do so.
+func (r relation) distance(v int) int {
+	d := 0
+	switch r {
+	case relEQ:
+		d = v
+		if d < 0 {
+			d = -d
+		}
+	case relNE:
+		if v == 0 {
+			d = 1
+		}
+	case relGT:
+		d = 1 - v
+	case relLE:
+		d = v
+	case relLT:
+		d = v + 1
+	case relGE:
+		d = -v
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// sym is a value computed from the program's inputs. Operations on
+// constants are folded as they are built, so a sym is only ever a
+// constant if no input contributed to it.
+type sym struct {
+	op   string // One of const, in, add, sub, mul, div, mod or diff
+	val  int    // The value of a const, or the index of an input
+	l, r *sym
+}
+
+func constSym(v int) *sym {
+	return &sym{op: "const", val: v}
+}
+
+// binarySym returns the result of applying op to l and r. The add, sub
+// and mul ops saturate like the machine's arithmetic, div and mod
+// truncate like DIV, and diff is unbounded subtraction, used only in
+// constraints.
+func binarySym(op string, l, r *sym) *sym {
+	s := &sym{op: op, l: l, r: r}
+	if l.op == "const" && r.op == "const" {
+		return constSym(s.eval(nil, nil))
+	}
+
+	// Saturating steps in the same direction combine, since l is always
+	// in range: (x - 1) - 1 is x - 2. This keeps the constraints from
+	// counting loops readable.
+	if (op == "add" || op == "sub") && r.op == "const" {
+		if r.val < 0 {
+			op, r = map[string]string{"add": "sub", "sub": "add"}[op], constSym(-r.val)
+			s = &sym{op: op, l: l, r: r}
+		}
+		if l.op == op && l.r.op == "const" && l.r.val >= 0 {
+			return &sym{op: op, l: l.l, r: constSym(l.r.val + r.val)}
+		}
+	}
+	return s
+}
+
+// eval returns the value of s given the inputs in. Values already
+// computed for the same inputs are kept in memo, if it isn't nil.
+// Division by zero yields zero; the path constraints rule it out.
+func (s *sym) eval(in []int, memo map[*sym]int) int {
+	if v, ok := memo[s]; ok {
+		return v
+	}
+
+	var v int
+	switch s.op {
+	case "const":
+		return s.val
+	case "in":
+		return in[s.val]
+	}
+
+	l, r := s.l.eval(in, memo), s.r.eval(in, memo)
+	switch s.op {
+	case "add":
+		v = boundsCap(l + r)
+	case "sub":
+		v = boundsCap(l - r)
+	case "mul":
+		v = boundsCap(l * r)
+	case "div":
+		if r != 0 {
+			v = l / r
+		}
+	case "mod":
+		if r != 0 {
+			v = l % r
+		}
+	case "diff":
+		v = l - r
+	}
+	if memo != nil {
+		memo[s] = v
+	}
+	return v
+}
+
+func (s *sym) String() string {
+	switch s.op {
+	case "const":
+		return fmt.Sprint(s.val)
+	case "in":
+		return fmt.Sprintf("in%d", s.val+1)
+	}
+	op := map[string]string{"add": "+", "sub": "-", "mul": "*", "div": "/", "mod": "%", "diff": "-"}[s.op]
+	return fmt.Sprintf("(%s %s %s)", s.l, op, s.r)
+}
+
+// constraint requires that e compare against zero with rel.
+type constraint struct {
+	e   *sym
+	rel relation
+}
+
+func (c constraint) String() string {
+	return fmt.Sprintf("%s %s 0", c.e, c.rel)
+}
+
+// solver searches for inputs satisfying a set of path constraints. It
+// doesn't prove anything: it evaluates candidate inputs against the
+// constraints exactly, moving each input in turn towards values that
+// satisfy more of them.
+type solver struct {
+	cands []int // Values worth trying for any input
+}
+
+// newSolver returns a solver that tries, besides the usual boundary
+// values, the constants held in mem and their neighbours.
+func newSolver(mem *[MemSize]int) *solver {
+	seen := map[int]bool{}
+	for _, v := range []int{0, 1, -1, 2, -2, 99999, -99999, 99998, -99998} {
+		seen[v] = true
+	}
+	for _, v := range mem {
+		for _, c := range []int{v, v - 1, v + 1, -v} {
+			seen[boundsCap(c)] = true
+		}
+	}
+
+	s := &solver{}
+	for v := range seen {
+		s.cands = append(s.cands, v)
+	}
+	sort.Ints(s.cands)
+	return s
+}
+
+// cost returns the total distance of in from satisfying cons.
+func cost(cons []constraint, in []int) int {
+	memo := map[*sym]int{}
+	c := 0
+	for _, k := range cons {
+		c += k.rel.distance(k.e.eval(in, memo))
+	}
+	return c
+}
+
+// solve returns n inputs satisfying cons, starting the search from
+// start, or false if it can't find any.
+func (s *solver) solve(cons []constraint, n int, start []int) ([]int, bool) {
+	x := make([]int, n)
+	copy(x, start)
+	best := cost(cons, x)
+	if best == 0 {
+		return x, true
+	}
+
+	for round := 0; round < 100; round++ {
+		improved := false
+		for i := range x {
+			v := x[i]
+			moves := append([]int{v - best, v + best, -v, v / 2, 2 * v}, s.cands...)
+			for _, d := range []int{1, 10, 100, 1000, 10000} {
+				moves = append(moves, v-d, v+d)
+			}
+			for _, m := range moves {
+				x[i] = boundsCap(m)
+				if c := cost(cons, x); c < best {
+					best, v, improved = c, x[i], true
+				}
+				if best == 0 {
+					return x, true
+				}
+			}
+			x[i] = v
+		}
+		if !improved {
+			break
+		}
+	}
+
+	// Local search gets stuck where constraints pull against each
+	// other, so fall back to trying every combination of candidates for
+	// small numbers of inputs.
+	if n <= 2 {
+		x := make([]int, n)
+		var try func(i int) bool
+		try = func(i int) bool {
+			if i == n {
+				return cost(cons, x) == 0
+			}
+			for _, c := range s.cands {
+				if x[i] = c; try(i + 1) {
+					return true
+				}
+			}
+			return false
+		}
+		if try(0) {
+			return x, true
+		}
+	}
+
+	return nil, false
+}
+
+// PathResult describes one path through a program found by Explore.
+type PathResult struct {
+	State       CPUState // How the path ended, or CPUok if it ran out of steps
+	PC          int      // The address of the instruction the path ended at
+	Inputs      []int    // Input values that drive execution down the path
+	Output      []int    // The values PUT along the path, given Inputs
+	Constraints []string // The conditions on the inputs that select the path
+}
+
+func (p PathResult) String() string {
+	end := fmt.Sprintf("%s at %02d", p.State, p.PC)
+	if p.State == CPUok {
+		end = fmt.Sprintf("still running at %02d", p.PC)
+	}
+	return fmt.Sprintf("%s with inputs %v, output %v", end, p.Inputs, p.Output)
+}
+
+// symState is the machine state along one path.
+type symState struct {
+	pc     int
+	ac, mq *sym
+	mem    [MemSize]*sym
+	devs   *[MemSize]bool // Cells a device is mapped at, read as inputs; nil if there are none
+	stack  [StackSize]int // Return addresses, an array so forks don't share it
+	depth  int            // The number of return addresses on stack
+	traps  trapState
+	isa    ISA          // How instructions are decoded
+	ch     int          // The selected I/O channel
+	ins    uint         // Bit n is set if channel n has input attached
+	outs   uint         // Bit n is set if channel n has output attached
+	cins   uint         // Likewise for character input
+	couts  uint         // And character output
+	inputs int          // The number of inputs read so far
+	cons   []constraint // The path constraints
+	wit    []int        // Inputs satisfying cons
+	out    []*sym
+	steps  int
+	end    CPUState // If not CPUok, the state the path ends in once it's taken from the worklist
+}
+
+// fork returns a copy of s that additionally requires c, or nil if
+// the solver can't find inputs that satisfy the result.
+func (s *symState) fork(sv *solver, c constraint) *symState {
+	cons := append(append([]constraint{}, s.cons...), c)
+	wit, ok := sv.solve(cons, s.inputs, s.wit)
+	if !ok {
+		return nil
+	}
+	f := *s
+	f.cons, f.wit = cons, wit
+	f.out = append([]*sym{}, s.out...)
+	return &f
+}
+
+// concrete returns the value of v under the current witness, adding
+// the constraint that it has that value if it depends on the inputs.
+func (s *symState) concrete(v *sym) int {
+	if v.op == "const" {
+		return v.val
+	}
+	val := v.eval(s.wit, nil)
+	s.cons = append(append([]constraint{}, s.cons...), constraint{binarySym("diff", v, constSym(val)), relEQ})
+	return val
+}
+
+// input returns a new unknown for the next value read.
+func (s *symState) input() *sym {
+	v := &sym{op: "in", val: s.inputs}
+	s.inputs++
+	s.wit = append(append([]int{}, s.wit...), 0)
+	return v
+}
+
+// load returns the value read from addr. A cell with a device mapped
+// at it reads a new input, as nothing is known about the device.
+func (s *symState) load(addr int) *sym {
+	if s.devs != nil && s.devs[addr] {
+		return s.input()
+	}
+	return s.mem[addr]
+}
+
+// store writes v to addr, unless a device is mapped there.
+func (s *symState) store(addr int, v *sym) {
+	if s.devs == nil || !s.devs[addr] {
+		s.mem[addr] = v
+	}
+}
+
+// trap enters the handler for fault cs, as Machine.fault does, saving
+// epc to return to. It returns false if there is no handler to run.
+func (s *symState) trap(cs CPUState, epc int) bool {
+	t, ok := faultTraps[cs]
+	if !ok || s.traps.handling || s.traps.set&(1<<uint(t)) == 0 {
+		return false
+	}
+	s.traps.epc, s.pc, s.traps.handling = epc, s.traps.vec[t], true
+	return true
+}
+
+// result summarises the path s ended on with state cs.
+func (s *symState) result(cs CPUState) PathResult {
+	r := PathResult{State: cs, PC: s.pc, Inputs: append([]int{}, s.wit...)}
+	if r.Inputs == nil {
+		r.Inputs = []int{}
+	}
+	r.Output = []int{}
+	for _, o := range s.out {
+		r.Output = append(r.Output, o.eval(s.wit, nil))
+	}
+	for _, c := range s.cons {
+		r.Constraints = append(r.Constraints, c.String())
+	}
+	return r
+}
+
+// explore runs the paths from s. Each path ends at a HLT, a fault or
+// after maxSteps instructions. Forked paths are run in the order they
+// were forked, so a loop that forks on every iteration can't starve
+// the branches forked before it. It returns the results and whether
+// every path was explored before maxPaths of them were found.
+func explore(s *symState, sv *solver, maxSteps, maxPaths int) ([]PathResult, bool) {
+	var results []PathResult
+	todo := []*symState{s}
+	for len(todo) > 0 {
+		if len(results) == maxPaths {
+			return results, false
+		}
+		s := todo[0]
+		todo = todo[1:]
+		if s.end != CPUok {
+			results = append(results, s.result(s.end))
+			continue
+		}
+
+		for {
+			if s.steps == maxSteps {
+				results = append(results, s.result(CPUok))
+				break
+			}
+			if !inBounds(s.pc) {
+				if s.trap(CPUbadinst, s.pc) {
+					continue
+				}
+				results = append(results, s.result(CPUbadinst))
+				break
+			}
+
+			// A cell computed from the inputs is fixed to its value
+			// under the current witness before being executed.
+			s.mem[s.pc] = constSym(s.concrete(s.mem[s.pc]))
+
+			i, mode, cs := s.isa.decode(s.mem[s.pc].val)
+			if cs != CPUok {
+				if s.trap(cs, s.pc) {
+					continue
+				}
+				results = append(results, s.result(cs))
+				break
+			}
+			if i.op == "HLT" {
+				results = append(results, s.result(CPUhalt))
+				break
+			}
+			// An indirect operand's pointer is fixed like the cell.
+			addr := i.addr
+			if mode == modeIndirect {
+				if addr = s.concrete(s.load(addr)); !inBounds(addr) {
+					if s.trap(CPUbadaddr, s.pc) {
+						continue
+					}
+					results = append(results, s.result(CPUbadaddr))
+					break
+				}
+			}
+			s.steps++
+
+			var m *sym
+			if mode == modeImmediate {
+				m = constSym(addr)
+			} else if read, _ := memAccess(i.op); read {
+				m = s.load(addr)
+			}
+			switch i.op {
+			case "JMP":
+				s.pc = addr
+				continue
+			case "CAL":
+				if s.depth == StackSize {
+					if s.trap(CPUstack, s.pc+1) {
+						continue
+					}
+					results = append(results, s.result(CPUstack))
+					s = nil
+					break
+				}
+				s.stack[s.depth] = s.pc + 1
+				s.depth++
+				s.pc = addr
+				continue
+			case "RET":
+				if s.depth == 0 {
+					if s.trap(CPUstack, s.pc+1) {
+						continue
+					}
+					results = append(results, s.result(CPUstack))
+					s = nil
+					break
+				}
+				s.depth--
+				s.pc = s.stack[s.depth]
+				continue
+			case "RTI":
+				if !s.traps.handling {
+					if s.trap(CPUbadinst, s.pc) {
+						continue
+					}
+					results = append(results, s.result(CPUbadinst))
+					s = nil
+					break
+				}
+				s.pc, s.traps.handling = s.traps.epc, false
+				continue
+			case "VEC":
+				t := s.concrete(s.ac)
+				if t < 0 || t >= int(numTraps) {
+					if s.trap(CPUbadinst, s.pc) {
+						continue
+					}
+					results = append(results, s.result(CPUbadinst))
+					s = nil
+					break
+				}
+				s.traps.vec[t] = addr
+				s.traps.set |= 1 << uint(t)
+			case "LEP":
+				s.traps.epc = s.concrete(m)
+			case "PEP":
+				s.store(addr, constSym(s.traps.epc))
+			case "JEQ", "JGT", "JLT", "JLE", "JNE":
+				if s.ac.op == "const" {
+					if jumpTaken(i.op, s.ac.val) {
+						s.pc = addr
+					} else {
+						s.pc++
+					}
+					continue
+				}
+				rels := branchRelations[i.op]
+				if f := s.fork(sv, constraint{s.ac, rels[1]}); f != nil {
+					f.pc++
+					todo = append(todo, f)
+				}
+				if s = s.fork(sv, constraint{s.ac, rels[0]}); s == nil {
+					break
+				}
+				s.pc = addr
+				continue
+			case "LAC":
+				s.ac = m
+			case "PAC":
+				s.store(addr, s.ac)
+			case "LMQ":
+				s.mq = m
+			case "PMQ":
+				s.store(addr, s.mq)
+			case "ADD":
+				s.ac = binarySym("add", s.ac, m)
+			case "SUB":
+				s.ac = binarySym("sub", s.ac, m)
+			case "MUL":
+				s.mq = binarySym("mul", s.mq, m)
+			case "DIV":
+				if m.op == "const" && m.val == 0 {
+					if s.trap(CPUdivzero, s.pc+1) {
+						continue
+					}
+					results = append(results, s.result(CPUdivzero))
+					s = nil
+					break
+				}
+				if m.op != "const" {
+					// The fork that divides by zero waits its turn
+					// like any other, so maxPaths still holds.
+					if f := s.fork(sv, constraint{m, relEQ}); f != nil {
+						if !f.trap(CPUdivzero, f.pc+1) {
+							f.end = CPUdivzero
+						}
+						todo = append(todo, f)
+					}
+					if s = s.fork(sv, constraint{m, relNE}); s == nil {
+						break
+					}
+				}
+				s.ac, s.mq = binarySym("mod", s.mq, m), binarySym("div", s.mq, m)
+			case "CHN":
+				n := s.concrete(m)
+				if !validChannel(n) {
+					if s.trap(CPUbadaddr, s.pc) {
+						continue
+					}
+					results = append(results, s.result(CPUbadaddr))
+					s = nil
+					break
+				}
+				s.ch = n
+			case "GET", "GCH":
+				ins := s.ins
+				if i.op == "GCH" {
+					ins = s.cins
+				}
+				if ins&(1<<uint(s.ch)) == 0 {
+					if s.trap(CPUbadaddr, s.pc) {
+						continue
+					}
+					results = append(results, s.result(CPUbadaddr))
+					s = nil
+					break
+				}
+				s.store(addr, s.input())
+			case "PUT", "PCH":
+				outs := s.outs
+				if i.op == "PCH" {
+					outs = s.couts
+				}
+				if outs&(1<<uint(s.ch)) == 0 {
+					if s.trap(CPUbadaddr, s.pc) {
+						continue
+					}
+					results = append(results, s.result(CPUbadaddr))
+					s = nil
+					break
+				}
+				s.out = append(s.out, m)
+			}
+			if s == nil {
+				break
+			}
+			s.pc++
+		}
+	}
+	return results, true
+}
+
+// newSymState returns the starting state for exploring mem from pc,
+// with the registers holding ac and mq and the return stack holding
+// stack.
+func newSymState(mem *[MemSize]int, pc, ac, mq int, stack []int) *symState {
+	s := &symState{pc: pc, ac: constSym(ac), mq: constSym(mq)}
+	s.depth = copy(s.stack[:], stack)
+	for a, v := range mem {
+		s.mem[a] = constSym(v)
+	}
+	return s
+}
+
+// Explore runs the program symbolically from address 0, treating each
+// value read by GET as an unknown in [-99999, 99999]. Where a
+// conditional jump or DIV depends on the inputs, both outcomes are
+// explored if inputs can be found that lead to them. Each path ends at
+// a HLT, a fault or after maxSteps instructions, and exploration stops
+// once maxPaths paths have been found; zero limits mean 1000 steps and
+// 100 paths. Arithmetic saturates and DIV truncates just as they do in
+// the machine, and faults run the program's trap handlers. Interrupts
+// aren't modelled. Only I/O channel 0 is attached; values read and
+// written on it are the path's inputs and output. Character codes
+// read by GCH and written by PCH are treated like GET and PUT values.
+//
+// The search for inputs isn't exhaustive, so paths that need unusual
+// inputs may be missed. The returned bool is false if exploration was
+// cut short by maxPaths.
+func (p *Program) Explore(maxSteps, maxPaths int) ([]PathResult, bool) {
+	return p.ExploreISA(ISAStandard, maxSteps, maxPaths)
+}
+
+// ExploreISA is like Explore, but decodes instructions with isa. The
+// pointer of an indirect operand is fixed to its value under the
+// path's inputs, as cells computed from the inputs are when executed.
+func (p *Program) ExploreISA(isa ISA, maxSteps, maxPaths int) ([]PathResult, bool) {
+	s := newSymState(&p.mem, 0, 0, 0, nil)
+	s.isa = isa
+	s.ins, s.outs, s.cins, s.couts = 1, 1, 1, 1
+	return exploreFrom(s, &p.mem, maxSteps, maxPaths)
+}
+
+// Explore runs the machine symbolically from its current state, as
+// Program.ExploreISA does with the machine's instruction set. I/O may
+// use any channel attached to the machine, and values read and written
+// on all of them are treated as a single stream of inputs and a single
+// output. Each value read from a mapped device is a new input too, and
+// values written to devices are dropped. The machine itself is left
+// untouched.
+func (h *Machine) Explore(maxSteps, maxPaths int) ([]PathResult, bool) {
+	s := newSymState(&h.mem, h.pc, h.ac, h.mq, h.stack)
+	s.traps, s.ch, s.isa = h.traps, h.ch, h.isa
+	var devs [MemSize]bool
+	for a, m := range h.devs {
+		devs[a] = m != nil
+	}
+	s.devs = &devs
+	for n, c := range h.chans {
+		if c.in != nil {
+			s.ins |= 1 << uint(n)
+		}
+		if c.out != nil {
+			s.outs |= 1 << uint(n)
+		}
+		if c.cin != nil {
+			s.cins |= 1 << uint(n)
+		}
+		if c.cout != nil {
+			s.couts |= 1 << uint(n)
+		}
+	}
+	return exploreFrom(s, &h.mem, maxSteps, maxPaths)
+}
+
+// exploreFrom explores the paths from s through the program in mem.
+func exploreFrom(s *symState, mem *[MemSize]int, maxSteps, maxPaths int) ([]PathResult, bool) {
+	if maxSteps <= 0 {
+		maxSteps = exploreSteps
+	}
+	if maxPaths <= 0 {
+		maxPaths = explorePaths
+	}
+	return explore(s, newSolver(mem), maxSteps, maxPaths)
+}
+
+// WriteExploration writes a readable report of the results of Explore
+// to w.
+func WriteExploration(w io.Writer, results []PathResult, complete bool) error {
+	for n, r := range results {
+		if _, err := fmt.Fprintf(w, "Path %d: %s\n", n+1, r); err != nil {
+			return err
+		}
+		if len(r.Constraints) > 0 {
+			fmt.Fprintf(w, "    when %s\n", strings.Join(r.Constraints, " and "))
+		}
+	}
+	if !complete {
+		fmt.Fprintln(w, "Stopped before all paths were explored.")
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// checkPath runs p concretely with the inputs found for r and checks
+// that it ends the same way and writes the same output.
+func checkPath(t *testing.T, name string, p *Program, r PathResult) {
+	t.Helper()
+	in := r.Inputs
+	var out []int
+	get, put := func() (int, error) { v := in[0]; in = in[1:]; return v, nil }, func(i int) { out = append(out, i) }
+	h := New(WithInput(get), WithOutput(put), WithCharInput(get), WithCharOutput(put), WithConsole(&strings.Builder{}))
+	h.Load(p)
+	h.RunContext(context.Background(), exploreSteps)
+
+	if h.State() != r.State || fmt.Sprint(out) != fmt.Sprint(r.Output) {
+		t.Errorf("%s: running with inputs %v ended in %s writing %v; want %s writing %v", name, r.Inputs, h.State(), out, r.State, r.Output)
+	}
+}
+
+func TestExplore(t *testing.T) {
+	cases := []struct {
+		name string
+		prog string
+		want []string // The end of each path, as state@pc
+	}{
+		{"max", "0: 30030\n1: 30035\n2: 10030\n3: 21035\n4: 06007\n5: 31030\n6: 0\n7: 31035\n8: 0", []string{"CPUhalt@08", "CPUhalt@06"}},
+		{"divzero", "0: 30020\n1: 30021\n2: 12020\n3: 23021\n4: 13022\n5: 31022\n6: 0", []string{"CPUhalt@06", "CPUdivzero@03"}},
+		{"badaddr", "0: 30010\n1: 10010\n2: 01004\n3: 0\n4: 10075", []string{"CPUbadaddr@04", "CPUhalt@03"}},
+		// The JGT is only taken if the ADD doesn't saturate, which it
+		// always does for positive inputs.
+		{"saturate", "0: 30010\n1: 10010\n2: 20011\n3: 21011\n4: 02006\n5: 0\n6: 23012\n11: 99999\n12: 0", []string{"CPUhalt@05"}},
+		// The remainder takes the sign of the dividend.
+		{"remainder", "0: 30010\n1: 12010\n2: 23011\n3: 03005\n4: 0\n5: 31010\n6: 0\n11: 3", []string{"CPUhalt@06", "CPUhalt@04"}},
+		{"concrete", "0: 31002\n1: 0\n2: 7", []string{"CPUhalt@01"}},
+		{"loop", "0: 05000", []string{"CPUok@00"}},
+		{"subroutine", "0: 30010\n1: 10010\n2: 08005\n3: 31010\n4: 0\n5: 07007\n6: 09000\n7: 0", []string{"CPUhalt@07", "CPUhalt@04"}},
+		{"recurse", "0: 08000", []string{"CPUstack@00"}},
+		{"underflow", "0: 09000", []string{"CPUstack@00"}},
+		// A divide by zero handler prints the dividend and stops.
+		{"trap", "0: 10010\n1: 14020\n2: 30011\n3: 30012\n4: 12011\n5: 23012\n6: 13013\n7: 31013\n8: 0\n10: 2\n20: 31011\n21: 0", []string{"CPUhalt@08", "CPUhalt@21"}},
+		{"trapreturn", "0: 10010\n1: 14020\n2: 30011\n3: 23011\n4: 0\n10: 2\n20: 04000", []string{"CPUhalt@04", "CPUhalt@04"}},
+		{"badvec", "0: 10010\n1: 14004\n2: 0\n4: 0\n10: 7", []string{"CPUbadinst@01"}},
+		// Only channel 0 is attached.
+		{"channel", "0: 33010\n1: 31010\n2: 0\n10: 0", []string{"CPUhalt@02"}},
+		{"unattached", "0: 33010\n1: 31010\n2: 0\n10: 1", []string{"CPUbadaddr@01"}},
+		{"badchannel", "0: 33010\n1: 0\n10: 10", []string{"CPUbadaddr@00"}},
+		// Characters are read and written like numbers.
+		{"chars", "0: 34010\n1: 10010\n2: 21011\n3: 01005\n4: 0\n5: 35010\n6: 0\n11: 65", []string{"CPUhalt@06", "CPUhalt@04"}},
+	}
+
+	for _, c := range cases {
+		p, err := ParseProgram(strings.NewReader(c.prog))
+		if err != nil {
+			t.Fatalf("%s: ParseProgram() = %v; want nil", c.name, err)
+		}
+		results, complete := p.Explore(0, 0)
+		if !complete {
+			t.Errorf("%s: Explore() didn't complete", c.name)
+		}
+
+		var got []string
+		for _, r := range results {
+			got = append(got, fmt.Sprintf("%s@%02d", r.State, r.PC))
+			if r.State != CPUok {
+				checkPath(t, c.name, p, r)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("%s: Explore() paths = %v; want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestExploreFibonacci(t *testing.T) {
+	f, err := os.Open("../examples/fibonacci.hypo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := ParseProgram(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, complete := p.Explore(0, 5)
+	if complete || len(results) != 5 {
+		t.Fatalf("Explore(0, 5) = %d results, complete %t; want 5, false", len(results), complete)
+	}
+	for n, r := range results {
+		if len(r.Inputs) != 1 || r.Inputs[0] != n || len(r.Output) != n {
+			t.Errorf("Path %d = %v; want input [%d] with %d outputs", n, r, n, n)
+		}
+		checkPath(t, "fibonacci", p, r)
+	}
+
+	want := "in1 != 0 and (in1 - 1) != 0 and (in1 - 2) == 0"
+	if got := strings.Join(results[2].Constraints, " and "); got != want {
+		t.Errorf("Path 2 constraints = %q; want %q", got, want)
+	}
+}
+
+func TestExploreDivzeroLimit(t *testing.T) {
+	// Every iteration forks a path that divides by zero.
+	p, err := ParseProgram(strings.NewReader("0: 30020\n1: 23020\n2: 5000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, max := range []int{1, 2, 3} {
+		results, complete := p.Explore(0, max)
+		if complete || len(results) != max {
+			t.Errorf("Explore(0, %d) = %d results, complete %t; want %d, false", max, len(results), complete, max)
+		}
+	}
+}
+
+func TestExploreDivide(t *testing.T) {
+	f, err := os.Open("../examples/divide.hypo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := ParseProgram(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The loop forks on every iteration, but the path through the
+	// divide by zero handler is still found among the first few.
+	results, _ := p.Explore(0, 0)
+	trapped := false
+	for _, r := range results[:5] {
+		trapped = trapped || (r.State == CPUhalt && len(r.Output) == 1 && r.Output[0] == -99999)
+		checkPath(t, "divide", p, r)
+	}
+	if !trapped {
+		t.Errorf("Explore() = %v; want a path printing -99999 from the handler among the first 5", results[:5])
+	}
+}
+
+func TestBinarySym(t *testing.T) {
+	x := &sym{op: "in", val: 0}
+	cases := []struct {
+		s    *sym
+		want string
+	}{
+		{binarySym("add", constSym(2), constSym(3)), "5"},
+		{binarySym("add", constSym(99999), constSym(3)), "99999"},
+		{binarySym("sub", binarySym("sub", x, constSym(1)), constSym(2)), "(in1 - 3)"},
+		{binarySym("add", binarySym("sub", x, constSym(1)), constSym(-2)), "(in1 - 3)"},
+		{binarySym("add", binarySym("sub", x, constSym(1)), constSym(2)), "((in1 - 1) + 2)"},
+		{binarySym("mod", constSym(-7), constSym(3)), "-1"},
+		{binarySym("div", constSym(-7), constSym(3)), "-2"},
+	}
+	for i, c := range cases {
+		if got := c.s.String(); got != c.want {
+			t.Errorf("%02d: sym = %s; want %s", i, got, c.want)
+		}
+	}
+
+	// Saturation is applied at each step, so the two forms differ.
+	s := binarySym("add", binarySym("sub", x, constSym(1)), constSym(2))
+	if got := s.eval([]int{-99999}, nil); got != -99997 {
+		t.Errorf("((-99999 - 1) + 2) = %d; want -99997", got)
+	}
+}
@@ -0,0 +1,507 @@
+// This file contains the transpiler, which turns a program into
+// standalone Go source.
+
+package machine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Errors returned by Transpile and TranspileISA for programs they
+// can't translate.
+var (
+	ErrTransChars = errors.New("Programs using character I/O can't be transpiled")
+	ErrTransModes = errors.New("Programs using extended addressing modes can't be transpiled")
+)
+
+// transpileHeader is the start of every transpiled program. The main
+// function behaves like batch mode: GET reads whitespace separated
+// numbers from stdin, PUT writes one number per line to stdout and the
+// exit status reflects how the program stopped.
+const transpileHeader = `package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Exit codes, as used by hypo's batch mode.
+var exitCodes = map[string]int{
+	"CPUhalt":    0,
+	"CPUbadinst": 3,
+	"CPUbadaddr": 4,
+	"CPUdivzero": 5,
+	"CPUstack":   8,
+}
+
+const exitBadInput = 6
+
+func boundsCap(i int) int {
+	if i > 99999 {
+		return 99999
+	}
+	if i < -99999 {
+		return -99999
+	}
+	return i
+}
+
+func main() {
+	s := bufio.NewScanner(os.Stdin)
+	s.Split(bufio.ScanWords)
+	get := func() (int, error) {
+		if !s.Scan() {
+			if err := s.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		v, err := strconv.Atoi(s.Text())
+		if err != nil {
+			return 0, fmt.Errorf("Invalid input %%q", s.Text())
+		}
+		if v != boundsCap(v) {
+			return 0, fmt.Errorf("Invalid input %%d: outside -99999 to 99999", v)
+		}
+		return v, nil
+	}
+	w := bufio.NewWriter(os.Stdout)
+	put := func(v int) { fmt.Fprintln(w, v) }
+
+	state, pc, err := run(get, put)
+	w.Flush()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reading input at PC %%02d: %%v\n", pc, err)
+		os.Exit(exitBadInput)
+	}
+	if state != "CPUhalt" {
+		fmt.Fprintf(os.Stderr, "program terminated with %%s at PC %%02d\n", state, pc)
+	}
+	os.Exit(exitCodes[state])
+}
+
+// mem is the initial memory image.
+var mem = [%d]int{%s}
+
+// stackSize is the number of return addresses CAL can push.
+const stackSize = %d
+`
+
+// transpileInterpreter runs programs that modify their own code, use
+// traps or select I/O channels. It follows Step, minus the debugging
+// support. Only channel 0 is attached, to stdin and stdout.
+const transpileInterpreter = `
+// traps numbers the fault states that can be trapped, as VEC does.
+var traps = map[string]int{
+	"CPUbadinst": 0,
+	"CPUbadaddr": 1,
+	"CPUdivzero": 2,
+	"CPUstack":   3,
+	"CPUio":      5,
+}
+
+const numTraps = %d
+
+const numChannels = %d
+
+// run interprets the program, which modifies its own instructions,
+// uses traps or selects I/O channels. It returns the final CPU state
+// and PC, or an error if a GET failed.
+func run(get func() (int, error), put func(int)) (string, int, error) {
+	var pc, ac, mq, epc, ch int
+	var stack [stackSize]int
+	var sp int
+	var vec [numTraps]int
+	var set [numTraps]bool
+	handling := false
+
+	// fault enters the handler for state, which is to return to at. It
+	// returns false if there is no handler to run.
+	fault := func(state string, at int) bool {
+		t, ok := traps[state]
+		if !ok || !set[t] || handling {
+			return false
+		}
+		epc, pc, handling = at, vec[t], true
+		return true
+	}
+
+	for {
+		if pc < 0 || pc >= len(mem) {
+			if fault("CPUbadinst", pc) {
+				continue
+			}
+			return "CPUbadinst", pc, nil
+		}
+		op, x := mem[pc]/1000, mem[pc]%%1000
+		switch op {
+		case 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 20, 21, 22, 23, 30, 31, 33:
+		default:
+			if fault("CPUbadinst", pc) {
+				continue
+			}
+			return "CPUbadinst", pc, nil
+		}
+		if x < 0 || x >= len(mem) {
+			if fault("CPUbadaddr", pc) {
+				continue
+			}
+			return "CPUbadaddr", pc, nil
+		}
+
+		pc++
+		switch op {
+		case 0:
+			return "CPUhalt", pc, nil
+		case 1:
+			if ac == 0 {
+				pc = x
+			}
+		case 2:
+			if ac > 0 {
+				pc = x
+			}
+		case 3:
+			if ac < 0 {
+				pc = x
+			}
+		case 4:
+			if !handling {
+				if fault("CPUbadinst", pc-1) {
+					continue
+				}
+				return "CPUbadinst", pc - 1, nil
+			}
+			pc, handling = epc, false
+		case 5:
+			pc = x
+		case 6:
+			if ac <= 0 {
+				pc = x
+			}
+		case 7:
+			if ac != 0 {
+				pc = x
+			}
+		case 8:
+			if sp == stackSize {
+				if fault("CPUstack", pc) {
+					continue
+				}
+				return "CPUstack", pc, nil
+			}
+			stack[sp] = pc
+			sp++
+			pc = x
+		case 9:
+			if sp == 0 {
+				if fault("CPUstack", pc) {
+					continue
+				}
+				return "CPUstack", pc, nil
+			}
+			sp--
+			pc = stack[sp]
+		case 10:
+			ac = mem[x]
+		case 11:
+			mem[x] = ac
+		case 12:
+			mq = mem[x]
+		case 13:
+			mem[x] = mq
+		case 14:
+			if ac < 0 || ac >= numTraps {
+				if fault("CPUbadinst", pc-1) {
+					continue
+				}
+				return "CPUbadinst", pc - 1, nil
+			}
+			vec[ac], set[ac] = x, true
+		case 15:
+			epc = mem[x]
+		case 16:
+			mem[x] = epc
+		case 20:
+			ac = boundsCap(ac + mem[x])
+		case 21:
+			ac = boundsCap(ac - mem[x])
+		case 22:
+			mq = boundsCap(mq * mem[x])
+		case 23:
+			if mem[x] == 0 {
+				if fault("CPUdivzero", pc) {
+					continue
+				}
+				return "CPUdivzero", pc, nil
+			}
+			ac, mq = mq%%mem[x], mq/mem[x]
+		case 30:
+			if ch != 0 {
+				if fault("CPUbadaddr", pc-1) {
+					continue
+				}
+				return "CPUbadaddr", pc - 1, nil
+			}
+			v, err := get()
+			if err != nil {
+				if fault("CPUio", pc-1) {
+					continue
+				}
+				return "", pc - 1, err
+			}
+			mem[x] = v
+		case 31:
+			if ch != 0 {
+				if fault("CPUbadaddr", pc-1) {
+					continue
+				}
+				return "CPUbadaddr", pc - 1, nil
+			}
+			put(mem[x])
+		case 33:
+			if mem[x] < 0 || mem[x] >= numChannels {
+				if fault("CPUbadaddr", pc-1) {
+					continue
+				}
+				return "CPUbadaddr", pc - 1, nil
+			}
+			ch = mem[x]
+		}
+	}
+}
+`
+
+// selfModifying reports whether a reachable instruction in p might
+// write to a reachable instruction.
+func selfModifying(p *Program) bool {
+	code := reachable(&p.mem, 0)
+	for a := range p.mem {
+		if !code[a] {
+			continue
+		}
+		i, cs := Decode(p.mem[a])
+		if _, write := memAccess(i.op); cs == CPUok && write && code[i.addr] {
+			return true
+		}
+	}
+	return false
+}
+
+// usesModes reports whether a cell of p reachable when decoding with
+// isa decodes differently than in the standard instruction set.
+func usesModes(p *Program, isa ISA) bool {
+	code := isa.reachable(&p.mem, 0)
+	for a, v := range p.mem {
+		i, m, cs := isa.decode(v)
+		if si, scs := Decode(v); code[a] && (i != si || m != modeDirect || cs != scs) {
+			return true
+		}
+	}
+	return false
+}
+
+// usesTraps reports whether a reachable instruction in p uses the trap
+// registers.
+func usesTraps(p *Program) bool {
+	code := reachable(&p.mem, 0)
+	for a := range p.mem {
+		switch i, cs := Decode(p.mem[a]); {
+		case !code[a] || cs != CPUok:
+		case i.op == "VEC" || i.op == "RTI" || i.op == "LEP" || i.op == "PEP":
+			return true
+		}
+	}
+	return false
+}
+
+// usesChannels reports whether a reachable instruction in p selects
+// an I/O channel.
+func usesChannels(p *Program) bool {
+	return usesOp(p, "CHN")
+}
+
+// usesOp reports whether a reachable instruction in p is one of ops.
+func usesOp(p *Program, ops ...string) bool {
+	code := reachable(&p.mem, 0)
+	for a := range p.mem {
+		i, cs := Decode(p.mem[a])
+		if !code[a] || cs != CPUok {
+			continue
+		}
+		for _, op := range ops {
+			if i.op == op {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jumpCond is the Go condition under which each conditional jump is
+// taken.
+var jumpCond = map[string]string{
+	"JEQ": "ac == 0",
+	"JGT": "ac > 0",
+	"JLT": "ac < 0",
+	"JLE": "ac <= 0",
+	"JNE": "ac != 0",
+}
+
+// statement returns the Go statements for the instruction at a. Each
+// leaves control with the state and PC that Step would.
+func statement(a int, i Instruction, cs CPUState) string {
+	if cs != CPUok {
+		return fmt.Sprintf("return %q, %d, nil", cs, a)
+	}
+	x := fmt.Sprintf("mem[%d]", i.addr)
+	switch i.op {
+	case "HLT":
+		return fmt.Sprintf("return \"CPUhalt\", %d, nil", a+1)
+	case "JMP":
+		return fmt.Sprintf("goto l%02d", i.addr)
+	case "JEQ", "JGT", "JLT", "JLE", "JNE":
+		return fmt.Sprintf("if %s {\n\t\tgoto l%02d\n\t}", jumpCond[i.op], i.addr)
+	case "CAL":
+		return fmt.Sprintf("if sp == stackSize {\n\t\treturn \"CPUstack\", %d, nil\n\t}\n\tstack[sp] = %d\n\tsp++\n\tgoto l%02d", a+1, a+1, i.addr)
+	case "RET":
+		return fmt.Sprintf("if sp == 0 {\n\t\treturn \"CPUstack\", %d, nil\n\t}\n\tsp--\n\tgoto ret", a+1)
+	case "LAC":
+		return "ac = " + x
+	case "PAC":
+		return x + " = ac"
+	case "LMQ":
+		return "mq = " + x
+	case "PMQ":
+		return x + " = mq"
+	case "ADD":
+		return fmt.Sprintf("ac = boundsCap(ac + %s)", x)
+	case "SUB":
+		return fmt.Sprintf("ac = boundsCap(ac - %s)", x)
+	case "MUL":
+		return fmt.Sprintf("mq = boundsCap(mq * %s)", x)
+	case "DIV":
+		return fmt.Sprintf("if %s == 0 {\n\t\treturn \"CPUdivzero\", %d, nil\n\t}\n\tac, mq = mq%%%s, mq/%s", x, a+1, x, x)
+	case "GET":
+		return fmt.Sprintf("if v, err := get(); err != nil {\n\t\treturn \"\", %d, err\n\t} else {\n\t\t%s = v\n\t}", a, x)
+	case "PUT":
+		return fmt.Sprintf("put(%s)", x)
+	}
+	return fmt.Sprintf("return \"CPUbadinst\", %d, nil", a)
+}
+
+// Transpile writes a standalone Go program to w that behaves like the
+// program run in batch mode, producing the same output and final
+// state. Each reachable instruction becomes a statement, commented
+// with its disassembly, and jumps become gotos. RET jumps to a switch
+// over the return addresses pushed by CAL. A program that might modify
+// its own instructions, uses traps or selects I/O channels is instead
+// run by an interpreter embedded in the output. The source names the
+// program in a comment. Programs using GCH or PCH aren't supported,
+// and return ErrTransChars.
+func (p *Program) Transpile(w io.Writer, source string) error {
+	return p.TranspileISA(w, source, ISAStandard)
+}
+
+// TranspileISA is like Transpile, but for the program decoded with
+// isa. Addressing modes other than direct aren't supported, so a
+// program whose code decodes differently than in the standard
+// instruction set, or that might modify its own instructions, can only
+// be transpiled for ISAStandard; others return ErrTransModes.
+func (p *Program) TranspileISA(w io.Writer, source string, isa ISA) error {
+	if isa != ISAStandard && (usesModes(p, isa) || selfModifying(p)) {
+		return ErrTransModes
+	}
+	if usesOp(p, "GCH", "PCH") {
+		return ErrTransChars
+	}
+
+	var vals []string
+	for _, v := range p.mem {
+		vals = append(vals, fmt.Sprint(v))
+	}
+	if _, err := fmt.Fprintf(w, "// Code generated by hypo from %s. DO NOT EDIT.\n\n", source); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, transpileHeader, MemSize, strings.Join(vals, ", "), StackSize)
+
+	if selfModifying(p) || usesTraps(p) || usesChannels(p) {
+		fmt.Fprintf(w, transpileInterpreter, numTraps, NumChannels)
+		return nil
+	}
+
+	code := reachable(&p.mem, 0)
+	var target [MemSize]bool
+	var returns []int // The return addresses CAL pushes, if the program can RET
+	calls, rets := false, false
+	for a := range p.mem {
+		i, cs := Decode(p.mem[a])
+		if !code[a] || cs != CPUok {
+			continue
+		}
+		switch {
+		case i.op == "JMP" || i.op == "CAL" || conditional(i.op):
+			target[i.addr] = true
+			calls = calls || i.op == "CAL"
+		case i.op == "RET":
+			rets = true
+		}
+	}
+	if rets {
+		for a := range p.mem {
+			if i, cs := Decode(p.mem[a]); code[a] && cs == CPUok && i.op == "CAL" && inBounds(a+1) {
+				returns = append(returns, a+1)
+				target[a+1] = true
+			}
+		}
+	}
+
+	fmt.Fprintf(w, `
+// run executes the program. It returns the final CPU state and PC, or
+// an error if a GET failed.
+func run(get func() (int, error), put func(int)) (string, int, error) {
+	var ac, mq int
+	_, _ = ac, mq // Not every program uses both
+`)
+	if calls || rets {
+		fmt.Fprintf(w, "\tvar stack [stackSize]int\n\tvar sp int\n")
+	}
+	for a := range p.mem {
+		if !code[a] {
+			continue
+		}
+		if target[a] {
+			fmt.Fprintf(w, "l%02d:\n", a)
+		}
+		i, cs := Decode(p.mem[a])
+		inst := "???"
+		if cs == CPUok {
+			inst = i.String()
+		}
+		if c := p.comments[a]; c != "" {
+			inst += "  // " + c
+		}
+		fmt.Fprintf(w, "\t// %02d: %s\n\t%s\n", a, inst, statement(a, i, cs))
+
+		// Running off the end of memory is a fault at the next PC.
+		if a == MemSize-1 && cs == CPUok && i.op != "HLT" && i.op != "JMP" && i.op != "CAL" && i.op != "RET" {
+			fmt.Fprintf(w, "\treturn \"CPUbadinst\", %d, nil\n", MemSize)
+		}
+	}
+
+	if rets {
+		fmt.Fprintf(w, "ret:\n\tswitch stack[sp] {\n")
+		for _, r := range returns {
+			fmt.Fprintf(w, "\tcase %d:\n\t\tgoto l%02d\n", r, r)
+		}
+		fmt.Fprintf(w, "\t}\n\treturn \"CPUbadinst\", stack[sp], nil\n")
+	}
+	fmt.Fprintln(w, "}")
+	return nil
+}
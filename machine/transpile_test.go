@@ -0,0 +1,137 @@
+package machine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// reference runs p on a Machine, returning what a transpiled program
+// should print to stdout and stderr, and its exit code.
+func reference(p *Program, in []int) (stdout, stderr string, code int) {
+	var out strings.Builder
+	h := New(WithInput(NumberGetter(strings.NewReader(strings.Trim(fmt.Sprint(in), "[]")))),
+		WithOutput(func(i int) { fmt.Fprintln(&out, i) }), WithConsole(&strings.Builder{}))
+	h.Load(p)
+	h.RunContext(context.Background(), 0)
+
+	codes := map[CPUState]int{CPUhalt: 0, CPUbadinst: 3, CPUbadaddr: 4, CPUdivzero: 5, CPUio: 6, CPUstack: 8}
+	switch h.State() {
+	case CPUhalt:
+	case CPUio:
+		stderr = fmt.Sprintf("reading input at PC %02d: %v\n", h.PC(), h.InputError())
+	default:
+		stderr = fmt.Sprintf("program terminated with %s at PC %02d\n", h.State(), h.PC())
+	}
+	return out.String(), stderr, codes[h.State()]
+}
+
+// TestTranspile checks that transpiled programs behave like the
+// Machine. It needs the go command to build them.
+func TestTranspile(t *testing.T) {
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	load := func(path string) string {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	cases := []struct {
+		name    string
+		prog    string
+		inputs  [][]int
+		interps bool // Whether the program needs the interpreter
+	}{
+		{"max", load("../examples/max.hypo"), [][]int{{3, 9}, {9, 3}, {-5, 5}}, false},
+		{"fibonacci", load("../examples/fibonacci.hypo"), [][]int{{0}, {10}, {}}, false},
+		{"quine", load("../examples/quine.hypo"), [][]int{{}}, true},
+		{"divzero", "0: 30020\n1: 30021\n2: 12020\n3: 23021\n4: 13022\n5: 31022\n6: 0", [][]int{{7, 2}, {-7, 2}, {7, 0}}, false},
+		{"saturate", "0: 30010\n1: 12010\n2: 22010\n3: 13011\n4: 31011\n5: 0", [][]int{{400}, {-400}, {100000}, {-100000}}, false},
+		{"badinst", "0: 10003\n1: 07004\n2: 0\n3: 1\n4: 99000", [][]int{{}}, false},
+		{"badaddr", "0: 30010\n1: 10010\n2: 01004\n3: 0\n4: 10075", [][]int{{0}, {1}}, false},
+		{"offend", "0: 05048\n48: 10000\n49: 31000", [][]int{{}}, false},
+		{"negative", "0: -5", [][]int{{}}, false},
+		{"subroutine", load("../examples/subroutine.hypo"), [][]int{{3, -4, 0}, {-99999, 7, 1}}, false},
+		{"callend", "0: 08049\n49: 09000", [][]int{{}}, false},
+		{"recurse", "0: 08000", [][]int{{}}, false},
+		// Only channel 0 is attached, as in batch mode.
+		{"channels", "0: 33010\n1: 30011\n2: 31011\n3: 33012\n4: 31011\n5: 0\n10: 0\n12: 2", [][]int{{5}}, true},
+		{"badchannel", "0: 33010\n1: 0\n10: 10", [][]int{{}}, true},
+		{"underflow", "0: 30010\n1: 10010\n2: 01004\n3: 08005\n4: 09000\n5: 0", [][]int{{0}, {1}}, false},
+		{"callselfmod", "0: 08003\n1: 31010\n2: 0\n3: 10005\n4: 11001\n5: 09000\n10: 42", [][]int{{}}, true},
+		{"divide", load("../examples/divide.hypo"), [][]int{{7, 2, 5, 0, 0}, {9, 0, 0}}, true},
+		{"iotrap", "0: 10010\n1: 14020\n2: 30030\n3: 31030\n4: 0\n10: 5\n11: 3\n20: 15011\n21: 04000", [][]int{{4}, {}}, true},
+		{"traps", "0: 10010\n1: 14020\n2: 10011\n3: 14021\n4: 99000\n5: 09000\n6: 0\n10: 0\n11: 3\n20: 16030\n21: 15031\n22: 31030\n23: 04000\n31: 6", [][]int{{}}, true},
+		{"rti", "0: 04000", [][]int{{}}, true},
+		{"selfmod", "0: 10004\n1: 11002\n2: 0\n3: 0\n4: 31010\n10: 42", [][]int{{}}, true},
+	}
+
+	dir := t.TempDir()
+	for _, c := range cases {
+		p, err := ParseProgram(strings.NewReader(c.prog))
+		if err != nil {
+			t.Fatalf("%s: ParseProgram() = %v", c.name, err)
+		}
+		if got := selfModifying(p) || usesTraps(p) || usesChannels(p); got != c.interps {
+			t.Errorf("%s: selfModifying() || usesTraps() || usesChannels() = %t; want %t", c.name, got, c.interps)
+		}
+
+		src := filepath.Join(dir, c.name+".go")
+		f, err := os.Create(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Transpile(f, c.name+".hypo"); err != nil {
+			t.Fatalf("%s: Transpile() = %v; want nil", c.name, err)
+		}
+		f.Close()
+
+		bin := filepath.Join(dir, c.name)
+		if out, err := exec.Command(gobin, "build", "-o", bin, src).CombinedOutput(); err != nil {
+			t.Errorf("%s: building transpiled program: %v\n%s", c.name, err, out)
+			continue
+		}
+
+		for _, in := range c.inputs {
+			var stdout, stderr strings.Builder
+			cmd := exec.Command(bin)
+			cmd.Stdin = strings.NewReader(strings.Trim(fmt.Sprint(in), "[]"))
+			cmd.Stdout, cmd.Stderr = &stdout, &stderr
+			code := 0
+			if err := cmd.Run(); err != nil {
+				var ee *exec.ExitError
+				if !errors.As(err, &ee) {
+					t.Fatal(err)
+				}
+				code = ee.ExitCode()
+			}
+
+			wantOut, wantErr, wantCode := reference(p, in)
+			if stdout.String() != wantOut || stderr.String() != wantErr || code != wantCode {
+				t.Errorf("%s: with input %v got %q, %q, exit %d; want %q, %q, exit %d", c.name, in, stdout.String(), stderr.String(), code, wantOut, wantErr, wantCode)
+			}
+		}
+	}
+}
+
+func TestTranspileChars(t *testing.T) {
+	for i, prog := range []string{"0: 35002\n1: 0\n2: 72", "0: 34002\n1: 0"} {
+		p, err := ParseProgram(strings.NewReader(prog))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Transpile(&strings.Builder{}, "chars.hypo"); err != ErrTransChars {
+			t.Errorf("%02d: Transpile(%q) = %v; want %v", i, prog, err, ErrTransChars)
+		}
+	}
+}
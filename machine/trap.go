@@ -0,0 +1,188 @@
+// This file contains traps: handlers a program can install to recover
+// from faults, and interrupts raised from outside the program.
+
+package machine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Trap identifies a fault or interrupt that can be handled by the
+// program. A program installs a handler for trap n by loading n into
+// the AC and executing VEC with the handler's address. Handlers for
+// TrapBadInst, TrapBadAddr and TrapIO return to the instruction that
+// faulted, so they must move EPC past it with LEP unless it should be
+// retried; otherwise RTI faults again.
+type Trap int
+
+const (
+	TrapBadInst Trap = iota // CPUbadinst
+	TrapBadAddr             // CPUbadaddr
+	TrapDivZero             // CPUdivzero
+	TrapStack               // CPUstack
+	TrapTimer               // Raised by the timer set up with WithTimer
+	TrapIO                  // CPUio
+	numTraps
+)
+
+func (t Trap) String() string {
+	switch t {
+	case TrapBadInst:
+		return "badinst"
+	case TrapBadAddr:
+		return "badaddr"
+	case TrapDivZero:
+		return "divzero"
+	case TrapStack:
+		return "stack"
+	case TrapTimer:
+		return "timer"
+	case TrapIO:
+		return "io"
+	}
+	return "unknown"
+}
+
+// ParseTrap returns the Trap named s, as written by String.
+func ParseTrap(s string) (Trap, bool) {
+	for t := TrapBadInst; t < numTraps; t++ {
+		if t.String() == s {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// faultTraps maps the fault states that can be trapped to their traps.
+var faultTraps = map[CPUState]Trap{
+	CPUbadinst: TrapBadInst,
+	CPUbadaddr: TrapBadAddr,
+	CPUdivzero: TrapDivZero,
+	CPUstack:   TrapStack,
+	CPUio:      TrapIO,
+}
+
+// trapState is the machine state used by traps. Its zero value has no
+// handlers installed.
+type trapState struct {
+	vec      [numTraps]int // The handler address for each trap in set
+	set      uint          // Bit t is set if trap t has a handler
+	pending  uint          // Bit t is set if interrupt t is waiting to be taken
+	epc      int           // The saved PC, where RTI resumes
+	handling bool          // Whether a handler is running
+	ticks    int           // Instructions executed since the timer last fired
+}
+
+// SetTrap installs a handler at addr for trap t, as VEC does.
+func (h *Machine) SetTrap(t Trap, addr int) {
+	h.traps.vec[t] = addr
+	h.traps.set |= 1 << uint(t)
+}
+
+// ClearTrap removes the handler for trap t, if any.
+func (h *Machine) ClearTrap(t Trap) {
+	h.traps.set &^= 1 << uint(t)
+}
+
+// TrapHandler returns the address of the handler for trap t, and
+// whether one is installed.
+func (h *Machine) TrapHandler(t Trap) (int, bool) {
+	return h.traps.vec[t], h.traps.set&(1<<uint(t)) != 0
+}
+
+// EPC returns the saved PC, the address the most recent trap handler
+// returns to.
+func (h *Machine) EPC() int {
+	return h.traps.epc
+}
+
+// Interrupt raises interrupt t. It is taken before the next
+// instruction, or after RTI if a handler is already running. It is
+// ignored if t has no handler.
+func (h *Machine) Interrupt(t Trap) {
+	if _, ok := h.TrapHandler(t); ok {
+		h.traps.pending |= 1 << uint(t)
+	}
+}
+
+// WithTimer raises TrapTimer after every n instructions.
+func WithTimer(n int) Option {
+	return func(h *Machine) { h.timer = n }
+}
+
+// enter starts the handler for trap t, saving PC to return to. It
+// returns false, leaving the machine alone, if t has no handler or a
+// handler is already running.
+func (h *Machine) enter(t Trap) bool {
+	addr, ok := h.TrapHandler(t)
+	if !ok || h.traps.handling {
+		return false
+	}
+	h.traps.epc, h.pc, h.traps.handling = h.pc, addr, true
+	return true
+}
+
+// fault stops the machine in fault state cs, unless the program has a
+// handler for it, which is entered instead. The handler returns to
+// the current PC: the faulting instruction itself for CPUbadinst,
+// CPUbadaddr and CPUio, and the one after it otherwise.
+func (h *Machine) fault(cs CPUState) {
+	if t, ok := faultTraps[cs]; ok && h.enter(t) {
+		if h.trace {
+			fmt.Fprintf(h.console, "Trap: %s\n", t)
+		}
+		return
+	}
+	h.state = cs
+}
+
+// interrupt takes the lowest numbered pending interrupt. It is called
+// by Step in place of executing an instruction.
+func (h *Machine) interrupt() {
+	if h.recording {
+		h.record(&offEnd, 0, CPUok) // Only registers change
+	}
+	if h.state == CPUpaused {
+		h.state = CPUok
+	}
+	h.hit = nil
+
+	t := TrapBadInst
+	for h.traps.pending&(1<<uint(t)) == 0 {
+		t++
+	}
+	h.traps.pending &^= 1 << uint(t)
+	if h.enter(t) && h.trace {
+		fmt.Fprintf(h.console, "Interrupt: %s\n", t)
+	}
+}
+
+// tick counts an executed instruction towards the timer.
+func (h *Machine) tick() {
+	if h.traps.ticks++; h.traps.ticks == h.timer {
+		h.traps.ticks = 0
+		h.Interrupt(TrapTimer)
+	}
+}
+
+// DumpTraps prints the installed trap handlers and the saved PC to the
+// console.
+func (h *Machine) DumpTraps() {
+	var vec []string
+	for t := TrapBadInst; t < numTraps; t++ {
+		if a, ok := h.TrapHandler(t); ok {
+			vec = append(vec, fmt.Sprintf("%s: %02d", t, a))
+		}
+	}
+	if len(vec) == 0 {
+		vec = append(vec, "(none)")
+	}
+	fmt.Fprintln(h.console, strings.Join(vec, "  "))
+
+	running := ""
+	if h.traps.handling {
+		running = " (handler running)"
+	}
+	fmt.Fprintf(h.console, "EPC: %02d%s\n", h.traps.epc, running)
+}
@@ -0,0 +1,193 @@
+package machine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseTrap(t *testing.T) {
+	for tr := TrapBadInst; tr < numTraps; tr++ {
+		if got, ok := ParseTrap(tr.String()); !ok || got != tr {
+			t.Errorf("ParseTrap(%q) = %s, %t; want %s, true", tr, got, ok, tr)
+		}
+	}
+	if _, ok := ParseTrap("bogus"); ok {
+		t.Errorf("ParseTrap(\"bogus\") = _, true; want false")
+	}
+}
+
+func TestTraps(t *testing.T) {
+	cases := []struct {
+		prog string
+		want string // Output, state, PC and EPC
+	}{
+		// Without a handler, faults stop the machine as before.
+		{"0: 23010\n1: 0", "[] CPUdivzero 1 0"},
+		// A divide by zero handler resumes after the DIV.
+		{"0: 10010\n1: 14020\n2: 23011\n3: 31010\n4: 0\n10: 2\n20: 16030\n21: 31030\n22: 04000", "[3 2] CPUhalt 5 3"},
+		// A handler for CPUbadinst can skip the faulting instruction by
+		// changing EPC.
+		{"0: 10010\n1: 14020\n2: 99000\n3: 0\n10: 0\n20: 15011\n21: 04000\n11: 3", "[] CPUhalt 4 3"},
+		// CPUbadaddr, and CPUstack on underflow.
+		{"0: 10010\n1: 14020\n2: 10075\n3: 0\n10: 1\n20: 16030\n21: 31030\n22: 0", "[2] CPUhalt 23 2"},
+		{"0: 10010\n1: 14020\n2: 09000\n3: 0\n10: 3\n20: 31010\n21: 04000", "[3] CPUhalt 4 3"},
+		// Running off the end of memory traps with EPC past the end.
+		{"0: 10010\n1: 14020\n2: 05049\n10: 0\n20: 16030\n21: 31030\n22: 0\n49: 31010", "[0 50] CPUhalt 23 50"},
+		// A fault in a handler is fatal.
+		{"0: 10010\n1: 14020\n2: 23011\n3: 0\n10: 2\n20: 23011", "[] CPUdivzero 21 3"},
+		// RTI outside a handler, and VEC with a bad trap number, are
+		// invalid instructions.
+		{"0: 04000", "[] CPUbadinst 0 0"},
+		{"0: 10010\n1: 14020\n10: 6", "[] CPUbadinst 1 0"},
+		// Failed input traps with EPC at the GET, which the handler
+		// skips.
+		{"0: 10010\n1: 14020\n2: 30030\n3: 31030\n4: 0\n10: 5\n20: 16031\n21: 31031\n22: 15011\n23: 04000\n11: 3", "[2 0] CPUhalt 5 3"},
+	}
+
+	for i, c := range cases {
+		p, err := ParseProgram(strings.NewReader(c.prog))
+		if err != nil {
+			t.Fatalf("%02d: ParseProgram() = %v", i, err)
+		}
+		out := []int{}
+		h := New(WithOutput(func(v int) { out = append(out, v) }), WithConsole(&strings.Builder{}))
+		h.Load(p)
+		h.RunContext(context.Background(), 100)
+
+		got := fmt.Sprintf("%v %s %d %d", out, h.state, h.pc, h.EPC())
+		if got != c.want {
+			t.Errorf("%02d: running %q = %s; want %s", i, c.prog, got, c.want)
+		}
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	// Count timer interrupts in 30 until the count reaches 3, while the
+	// main program loops.
+	prog := "0: 10010\n1: 14020\n2: 05002\n10: 4\n11: 1\n12: 3\n20: 10030\n21: 20011\n22: 11030\n23: 21012\n24: 01026\n25: 04000\n26: 0"
+	p, err := ParseProgram(strings.NewReader(prog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	h := New(WithTimer(10), WithConsole(&b))
+	h.Load(p)
+	h.ToggleTrace()
+	h.RunContext(context.Background(), 1000)
+	if h.state != CPUhalt || h.mem[30] != 3 || h.EPC() != 2 {
+		t.Errorf("Running with a timer = %s with count %d and EPC %d; want CPUhalt with count 3 and EPC 2", h.state, h.mem[30], h.EPC())
+	}
+	if n := strings.Count(b.String(), "Interrupt: timer\n"); n != 3 {
+		t.Errorf("Trace shows %d timer interrupts; want 3", n)
+	}
+
+	// Interrupts without a handler are ignored, and those raised while
+	// a handler runs wait for its RTI.
+	h = New(WithConsole(&strings.Builder{}))
+	h.Interrupt(TrapTimer)
+	if h.traps.pending != 0 {
+		t.Errorf("Interrupt() with no handler left pending = %b; want 0", h.traps.pending)
+	}
+	h.SetTrap(TrapTimer, 10)
+	h.mem[10] = 4000
+	h.Interrupt(TrapTimer)
+	h.Step()
+	h.Interrupt(TrapTimer)
+	if h.pc != 10 || !h.traps.handling {
+		t.Errorf("Interrupt() while handling = PC %d, handling %t; want PC 10, handling true", h.pc, h.traps.handling)
+	}
+	h.Step()
+	if h.pc != 0 || h.traps.handling || h.traps.pending == 0 {
+		t.Errorf("RTI = PC %d, handling %t, pending %b; want PC 0, not handling and an interrupt pending", h.pc, h.traps.handling, h.traps.pending)
+	}
+	h.Step()
+	if h.pc != 10 || !h.traps.handling {
+		t.Errorf("Step() after RTI = PC %d, handling %t; want the held interrupt taken", h.pc, h.traps.handling)
+	}
+
+	h.ClearTrap(TrapTimer)
+	if _, ok := h.TrapHandler(TrapTimer); ok {
+		t.Errorf("TrapHandler() after ClearTrap() = _, true; want false")
+	}
+}
+
+func TestTrapStepBack(t *testing.T) {
+	prog := "0: 10010\n1: 14020\n2: 23011\n3: 15012\n4: 0\n10: 2\n12: 7\n20: 16030\n21: 04000"
+	h := New(WithTimer(3), WithHistory(), WithConsole(&strings.Builder{}))
+	if err := h.LoadProgram(strings.NewReader(prog)); err != nil {
+		t.Fatal(err)
+	}
+	h.SetTrap(TrapTimer, 21)
+
+	type full struct {
+		m machineState
+		t trapState
+	}
+	var states []full
+	for !h.Halted() {
+		states = append(states, full{stateOf(h), h.traps})
+		h.Step()
+	}
+	for i := len(states) - 1; i >= 0; i-- {
+		h.StepBack()
+		if got := (full{stateOf(h), h.traps}); got != states[i] {
+			t.Errorf("%02d: after StepBack state = %+v; want %+v", i, got, states[i])
+		}
+	}
+}
+
+func TestTrapSnapshot(t *testing.T) {
+	h := New(WithTimer(5), WithConsole(&strings.Builder{}))
+	h.SetTrap(TrapDivZero, 20)
+	h.SetTrap(TrapTimer, 30)
+	h.Interrupt(TrapTimer)
+	h.Step()
+	h.Interrupt(TrapTimer)
+
+	var b bytes.Buffer
+	if err := h.SaveSnapshot(&b); err != nil {
+		t.Fatal(err)
+	}
+	g := New()
+	if err := g.LoadSnapshot(&b); err != nil {
+		t.Fatalf("g.LoadSnapshot() = %v; want nil", err)
+	}
+	if g.traps != h.traps || g.timer != 5 {
+		t.Errorf("Restored traps = %+v, timer %d; want %+v, timer 5", g.traps, g.timer, h.traps)
+	}
+
+	mem := "[" + strings.Repeat("0, ", MemSize-1) + "0]"
+	for i, s := range []string{
+		`{"version": 4, "state": "CPUok", "traps": {"bogus": 1}, "mem": ` + mem + `}`,
+		`{"version": 4, "state": "CPUok", "traps": {"timer": 50}, "mem": ` + mem + `}`,
+		`{"version": 4, "state": "CPUok", "pending": ["bogus"], "mem": ` + mem + `}`,
+		`{"version": 4, "state": "CPUok", "timer": -1, "mem": ` + mem + `}`,
+	} {
+		if err := g.LoadSnapshot(strings.NewReader(s)); err != ErrSnapBadData {
+			t.Errorf("%02d: g.LoadSnapshot(%q) = %v; want %v", i, s, err, ErrSnapBadData)
+		}
+	}
+}
+
+func TestDumpTraps(t *testing.T) {
+	var b bytes.Buffer
+	h := New(WithConsole(&b))
+	h.DumpTraps()
+	h.SetTrap(TrapBadAddr, 12)
+	h.SetTrap(TrapTimer, 40)
+	h.Interrupt(TrapTimer)
+	h.Step()
+	h.DumpTraps()
+
+	want := `(none)
+EPC: 00
+badaddr: 12  timer: 40
+EPC: 00 (handler running)
+`
+	if b.String() != want {
+		t.Errorf("DumpTraps() = %q; want %q", b.String(), want)
+	}
+}
@@ -0,0 +1,246 @@
+// This file contains the declarative test runner, which checks hypo
+// programs against the inputs and expected results listed in a spec
+// file.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bdwalton/hypo/machine"
+)
+
+// defaultSpecSteps is the step budget for each test case when the spec
+// doesn't set one, so that a looping program fails instead of hanging.
+const defaultSpecSteps = 100000
+
+// testSpec is the contents of a spec file. Program is a .hypo file, a
+// .hasm file to assemble or a .hyl file to compile, relative to the
+// spec file.
+type testSpec struct {
+	Program  string     `json:"program"`
+	MaxSteps int        `json:"max_steps"`
+	Cases    []testCase `json:"cases"`
+}
+
+// testCase is a single run of the program under test. An empty State
+// expects CPUhalt. Registers are keyed by pc, ac and mq and Memory by
+// address.
+type testCase struct {
+	Name      string         `json:"name"`
+	Input     []int          `json:"input"`
+	Output    []int          `json:"output"`
+	State     string         `json:"state"`
+	Registers map[string]int `json:"registers"`
+	Memory    map[string]int `json:"memory"`
+}
+
+// loadSpec reads a spec from r and the program it names. Relative
+// program paths are resolved against dir.
+func loadSpec(r io.Reader, dir string) (*testSpec, *machine.Program, error) {
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	var s testSpec
+	if err := d.Decode(&s); err != nil {
+		return nil, nil, fmt.Errorf("reading spec: %v", err)
+	}
+	if s.Program == "" {
+		return nil, nil, fmt.Errorf("spec doesn't name a program")
+	}
+	if s.MaxSteps <= 0 {
+		s.MaxSteps = defaultSpecSteps
+	}
+
+	path := s.Program
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var p *machine.Program
+	switch filepath.Ext(path) {
+	case ".hasm":
+		p, err = machine.Assemble(f)
+	case ".hyl":
+		p, err = machine.Compile(f)
+	default:
+		p, err = machine.ParseProgram(f)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &s, p, nil
+}
+
+// scriptGetter returns a Getter that supplies the values in in, in
+// order. Reading past the end is an error.
+func scriptGetter(in []int) machine.Getter {
+	next := 0
+	return func() (int, error) {
+		if next == len(in) {
+			return 0, fmt.Errorf("program read more than the %d input values given", len(in))
+		}
+		next++
+		return in[next-1], nil
+	}
+}
+
+// diffOutput describes the differences between the values a program
+// wrote and those it was expected to.
+func diffOutput(got, want []int) []string {
+	var diffs []string
+	for i := 0; i < len(got) && i < len(want); i++ {
+		if got[i] != want[i] {
+			diffs = append(diffs, fmt.Sprintf("output[%d]: got %d; want %d", i, got[i], want[i]))
+		}
+	}
+	if len(got) > len(want) {
+		diffs = append(diffs, fmt.Sprintf("output has %d unexpected values: %v", len(got)-len(want), got[len(want):]))
+	}
+	if len(want) > len(got) {
+		diffs = append(diffs, fmt.Sprintf("output is missing %d values: %v", len(want)-len(got), want[len(got):]))
+	}
+	return diffs
+}
+
+// runCase runs c against p and returns a description of each way the
+// run differed from the expectations, or an error if the case itself
+// is malformed.
+func runCase(p *machine.Program, c testCase, maxSteps int) (diffs []string, err error) {
+	want := machine.CPUState(machine.CPUhalt)
+	if c.State != "" {
+		var ok bool
+		if want, ok = machine.ParseCPUState(c.State); !ok {
+			return nil, fmt.Errorf("unknown CPU state %q", c.State)
+		}
+	}
+
+	var out []int
+	get, put := scriptGetter(c.Input), func(i int) { out = append(out, i) }
+	h := machine.New(
+		machine.WithInput(get),
+		machine.WithOutput(put),
+		machine.WithCharInput(get),
+		machine.WithCharOutput(put),
+		machine.WithConsole(io.Discard),
+	)
+	h.Load(p)
+
+	switch r, _ := h.RunContext(context.Background(), maxSteps); {
+	case r == machine.StopBudget:
+		diffs = append(diffs, fmt.Sprintf("program still running after %d steps at PC %02d", maxSteps, h.PC()))
+	case h.State() == want:
+	case h.State() == machine.CPUio:
+		diffs = append(diffs, fmt.Sprintf("at PC %02d: %v", h.PC(), h.InputError()))
+	default:
+		diffs = append(diffs, fmt.Sprintf("state: got %s at PC %02d; want %s", h.State(), h.PC(), want))
+	}
+
+	diffs = append(diffs, diffOutput(out, c.Output)...)
+
+	regs := map[string]int{"pc": h.PC(), "ac": h.AC(), "mq": h.MQ()}
+	for _, r := range sortedKeys(c.Registers) {
+		got, ok := regs[strings.ToLower(r)]
+		if !ok {
+			return nil, fmt.Errorf("unknown register %q", r)
+		}
+		if got != c.Registers[r] {
+			diffs = append(diffs, fmt.Sprintf("register %s: got %d; want %d", r, got, c.Registers[r]))
+		}
+	}
+
+	mem := h.Memory()
+	for _, k := range sortedKeys(c.Memory) {
+		a, err := strconv.Atoi(k)
+		if err != nil || a < 0 || a >= machine.MemSize {
+			return nil, fmt.Errorf("invalid memory address %q", k)
+		}
+		if mem[a] != c.Memory[k] {
+			diffs = append(diffs, fmt.Sprintf("memory %02d: got %d; want %d", a, mem[a], c.Memory[k]))
+		}
+	}
+
+	return diffs, nil
+}
+
+// sortedKeys returns the keys of m in order, so that diffs are
+// reported consistently.
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// runSpec runs every case in the spec file at path, writing PASS or
+// FAIL and any differences for each one to w. It returns the number
+// of cases that passed and failed.
+func runSpec(path string, w io.Writer) (passed, failed int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	s, p, err := loadSpec(f, filepath.Dir(path))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	fmt.Fprintf(w, "=== %s (%s)\n", path, s.Program)
+	for i, c := range s.Cases {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("case %d", i+1)
+		}
+
+		diffs, err := runCase(p, c, s.MaxSteps)
+		if err != nil {
+			return passed, failed, fmt.Errorf("%s: %v", name, err)
+		}
+		if len(diffs) == 0 {
+			passed++
+			fmt.Fprintf(w, "PASS: %s\n", name)
+			continue
+		}
+		failed++
+		fmt.Fprintf(w, "FAIL: %s\n", name)
+		for _, d := range diffs {
+			fmt.Fprintf(w, "    %s\n", d)
+		}
+	}
+	return passed, failed, nil
+}
+
+// specTest runs the spec files at paths and exits with status 0 if
+// every case passed, or 1 otherwise.
+func specTest(paths []string) {
+	code := exitHalt
+	var passed, failed int
+	for _, path := range paths {
+		p, f, err := runSpec(path, os.Stdout)
+		passed, failed = passed+p, failed+f
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			code = exitError
+		}
+	}
+	if failed > 0 {
+		code = exitError
+	}
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
+	os.Exit(code)
+}
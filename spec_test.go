@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/bdwalton/hypo/machine"
+)
+
+func TestRunSpecExample(t *testing.T) {
+	var out bytes.Buffer
+	passed, failed, err := runSpec("examples/max.test.json", &out)
+	if passed != 3 || failed != 0 || err != nil {
+		t.Errorf("runSpec(examples/max.test.json) = (%d, %d, %v); want (3, 0, nil)\n%s", passed, failed, err, out.String())
+	}
+}
+
+func TestRunCase(t *testing.T) {
+	// Reads two values and prints their sum.
+	p, err := machine.ParseProgram(strings.NewReader("0: 30010\n1: 30011\n2: 10010\n3: 20011\n4: 11012\n5: 31012\n6: 0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		c         testCase
+		wantDiffs []string
+	}{
+		{testCase{Input: []int{1, 2}, Output: []int{3}}, nil},
+		{testCase{Input: []int{1, 2}, Output: []int{3}, Registers: map[string]int{"AC": 3, "pc": 7, "mq": 0}, Memory: map[string]int{"12": 3}}, nil},
+		{testCase{Input: []int{1, 2}, Output: []int{4}}, []string{"output[0]: got 3; want 4"}},
+		{testCase{Input: []int{1, 2}}, []string{"output has 1 unexpected values: [3]"}},
+		{testCase{Input: []int{1, 2}, Output: []int{3, 4}}, []string{"output is missing 1 values: [4]"}},
+		{testCase{Input: []int{1, 2}, Output: []int{3}, State: "CPUbadaddr"}, []string{"state: got CPUhalt at PC 07; want CPUbadaddr"}},
+		{testCase{Input: []int{1}}, []string{"at PC 01: program read more than the 1 input values given"}},
+		{testCase{Input: []int{1}, State: "CPUio"}, nil},
+		{testCase{Input: []int{1, 2}, Output: []int{3}, Registers: map[string]int{"ac": 1}, Memory: map[string]int{"10": 2, "11": 2}}, []string{"register ac: got 3; want 1", "memory 10: got 1; want 2"}},
+	}
+
+	for i, c := range cases {
+		diffs, err := runCase(p, c.c, 100)
+		if err != nil {
+			t.Errorf("%02d: runCase() = %v; want nil", i, err)
+			continue
+		}
+		if strings.Join(diffs, "\n") != strings.Join(c.wantDiffs, "\n") {
+			t.Errorf("%02d: runCase() = %q; want %q", i, diffs, c.wantDiffs)
+		}
+	}
+}
+
+func TestRunCaseChars(t *testing.T) {
+	// Character codes are read from the input and written to the output
+	// like numbers.
+	p, err := machine.ParseProgram(strings.NewReader("0: 34010\n1: 35010\n2: 0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffs, err := runCase(p, testCase{Input: []int{72}, Output: []int{72}}, 100)
+	if err != nil || len(diffs) != 0 {
+		t.Errorf("runCase() = %q, %v; want no differences", diffs, err)
+	}
+}
+
+func TestRunCaseErrors(t *testing.T) {
+	p, err := machine.ParseProgram(strings.NewReader("0: 05000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diffs, err := runCase(p, testCase{}, 10); err != nil || len(diffs) != 1 || !strings.Contains(diffs[0], "still running") {
+		t.Errorf("runCase(loop) = (%q, %v); want a step budget failure", diffs, err)
+	}
+
+	for i, c := range []testCase{
+		{State: "CPUbogus"},
+		{Registers: map[string]int{"xx": 1}},
+		{Memory: map[string]int{"50": 1}},
+		{Memory: map[string]int{"a": 1}},
+	} {
+		if _, err := runCase(p, c, 10); err == nil {
+			t.Errorf("%02d: runCase(%+v) = nil error; want an error", i, c)
+		}
+	}
+}
+
+func TestLoadSpec(t *testing.T) {
+	cases := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{`{"program": "max.hypo", "cases": [{"input": [1, 2], "output": [2]}]}`, false},
+		{`{"program": "fibonacci.hasm"}`, false},
+		{`{"cases": []}`, true},
+		{`{"program": "max.hypo", "bogus": 1}`, true},
+		{`{"program": "missing.hypo"}`, true},
+		{`not json`, true},
+	}
+
+	for i, c := range cases {
+		s, _, err := loadSpec(strings.NewReader(c.spec), "examples")
+		if (err != nil) != c.wantErr {
+			t.Errorf("%02d: loadSpec(%q) = %v; want error %t", i, c.spec, err, c.wantErr)
+		}
+		if err == nil && s.MaxSteps != defaultSpecSteps {
+			t.Errorf("%02d: loadSpec(%q).MaxSteps = %d; want %d", i, c.spec, s.MaxSteps, defaultSpecSteps)
+		}
+	}
+}